@@ -0,0 +1,112 @@
+package httplog
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// OverflowPolicy determines what an AsyncLogFn does with a record when its
+// buffer is full.
+type OverflowPolicy int
+
+const (
+	// Block waits until there is room in the buffer, which blocks the request
+	// handler until the record is queued.
+	Block OverflowPolicy = iota
+	// DropNewest discards the record being logged.
+	DropNewest
+	// DropOldest discards the oldest record in the buffer to make room for the
+	// record being logged.
+	DropOldest
+)
+
+// AsyncLogFn logs records on a background goroutine, so that a slow LogFn does
+// not block request handlers. Its Log method can be used as a LogFn. Because
+// records passed to a LogFn are reused, each record is copied with
+// Record.Clone before it is queued. Close should be called on shutdown to log
+// any queued records.
+type AsyncLogFn struct {
+	fn      LogFn
+	policy  OverflowPolicy
+	records chan *Record
+	done    chan struct{}
+	dropped uint64
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+// NewAsyncLogFn returns an AsyncLogFn that calls fn for each record on a
+// background goroutine. Up to size records are buffered, after which policy
+// determines what happens to further records.
+func NewAsyncLogFn(fn LogFn, size int, policy OverflowPolicy) *AsyncLogFn {
+	a := &AsyncLogFn{
+		fn:      fn,
+		policy:  policy,
+		records: make(chan *Record, size),
+		done:    make(chan struct{}),
+	}
+	go a.run()
+	return a
+}
+
+func (a *AsyncLogFn) run() {
+	defer close(a.done)
+	for record := range a.records {
+		a.fn(record)
+	}
+}
+
+// Log queues a copy of record to be logged. Records logged after Close are
+// dropped.
+func (a *AsyncLogFn) Log(record *Record) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.closed {
+		atomic.AddUint64(&a.dropped, 1)
+		return
+	}
+	c := record.Clone()
+	switch a.policy {
+	case DropNewest:
+		select {
+		case a.records <- c:
+		default:
+			atomic.AddUint64(&a.dropped, 1)
+		}
+	case DropOldest:
+		for {
+			select {
+			case a.records <- c:
+				return
+			default:
+			}
+			select {
+			case <-a.records:
+				atomic.AddUint64(&a.dropped, 1)
+			default:
+			}
+		}
+	default:
+		a.records <- c
+	}
+}
+
+// Dropped returns the number of records that have been dropped, either because
+// of the overflow policy or because they were logged after Close.
+func (a *AsyncLogFn) Dropped() uint64 {
+	return atomic.LoadUint64(&a.dropped)
+}
+
+// Close stops accepting records and waits until all queued records have been
+// logged. It always returns nil.
+func (a *AsyncLogFn) Close() error {
+	a.mu.Lock()
+	if !a.closed {
+		a.closed = true
+		close(a.records)
+	}
+	a.mu.Unlock()
+	<-a.done
+	return nil
+}
@@ -0,0 +1,149 @@
+package httplog
+
+import (
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// gatedLogFn is a LogFn for testing AsyncLogFn. It records the request IDs of
+// the records it is called with, signals started on each call if the channel
+// has room, and then waits until gate is closed.
+type gatedLogFn struct {
+	started chan struct{}
+	gate    chan struct{}
+
+	mu     sync.Mutex
+	logged []string
+}
+
+func newGatedLogFn() *gatedLogFn {
+	return &gatedLogFn{started: make(chan struct{}, 1), gate: make(chan struct{})}
+}
+
+func (g *gatedLogFn) log(record *Record) {
+	select {
+	case g.started <- struct{}{}:
+	default:
+	}
+	<-g.gate
+	g.mu.Lock()
+	g.logged = append(g.logged, record.RequestID)
+	g.mu.Unlock()
+}
+
+func (g *gatedLogFn) ids() []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.logged
+}
+
+// logIDs logs a record with each of ids as its request ID, resetting the
+// record after each call as LoggingHandler does.
+func logIDs(a *AsyncLogFn, ids ...int) {
+	r := NewRecord()
+	for _, id := range ids {
+		r.RequestID = strconv.Itoa(id)
+		a.Log(r)
+		r.Reset()
+	}
+}
+
+func TestAsyncLogFnDrop(t *testing.T) {
+	for _, test := range []struct {
+		policy  OverflowPolicy
+		want    []string
+		dropped uint64
+	}{
+		{DropNewest, []string{"1", "2"}, 2},
+		{DropOldest, []string{"1", "4"}, 2},
+	} {
+		g := newGatedLogFn()
+		a := NewAsyncLogFn(g.log, 1, test.policy)
+		logIDs(a, 1)
+		<-g.started // 1 is being logged, so the buffer is empty.
+		logIDs(a, 2, 3, 4)
+		if got := a.Dropped(); got != test.dropped {
+			t.Errorf("policy %d: dropped %d, want %d", test.policy, got, test.dropped)
+		}
+		close(g.gate)
+		a.Close()
+		if got := g.ids(); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("policy %d: logged %q, want %q", test.policy, got, test.want)
+		}
+	}
+}
+
+func TestAsyncLogFnBlock(t *testing.T) {
+	g := newGatedLogFn()
+	a := NewAsyncLogFn(g.log, 1, Block)
+	logIDs(a, 1)
+	<-g.started
+	logIDs(a, 2)
+	returned := make(chan struct{})
+	go func() {
+		logIDs(a, 3)
+		close(returned)
+	}()
+	select {
+	case <-returned:
+		t.Fatal("Log returned while the buffer was full")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(g.gate)
+	<-returned
+	a.Close()
+	if got, want := g.ids(), []string{"1", "2", "3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("logged %q, want %q", got, want)
+	}
+	if got := a.Dropped(); got != 0 {
+		t.Errorf("dropped %d, want 0", got)
+	}
+}
+
+func TestAsyncLogFnClose(t *testing.T) {
+	// Close logs the queued records, and records logged after Close are
+	// dropped.
+	g := newGatedLogFn()
+	close(g.gate)
+	a := NewAsyncLogFn(g.log, 10, Block)
+	logIDs(a, 1, 2, 3, 4, 5)
+	a.Close()
+	if got, want := g.ids(), []string{"1", "2", "3", "4", "5"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("logged %q, want %q", got, want)
+	}
+	logIDs(a, 6)
+	if err := a.Close(); err != nil {
+		t.Errorf("second Close returned %v", err)
+	}
+	if got := len(g.ids()); got != 5 {
+		t.Errorf("logged %d records after Close, want 5", got)
+	}
+	if got := a.Dropped(); got != 1 {
+		t.Errorf("dropped %d, want 1", got)
+	}
+}
+
+func TestAsyncLogFnConcurrent(t *testing.T) {
+	// Run with -race.
+	g := newGatedLogFn()
+	close(g.gate)
+	a := NewAsyncLogFn(g.log, 4, DropOldest)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				logIDs(a, i*100+j)
+			}
+		}(i)
+	}
+	wg.Wait()
+	a.Close()
+	if got := uint64(len(g.ids())) + a.Dropped(); got != 400 {
+		t.Errorf("logged and dropped %d records, want 400", got)
+	}
+}
@@ -0,0 +1,322 @@
+package httplog
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ClientResolver determines the address of the client that made a request,
+// which may differ from the peer of the connection if the request was
+// forwarded by proxies. Its fields are options; the zero value is ready to use.
+// A nil *ClientResolver behaves like the zero value.
+type ClientResolver struct {
+	// DropObfuscated causes obfuscated identifiers in the Forwarded header,
+	// such as for=_hidden, and the identifier "unknown", to be ignored, as if
+	// the header had no "for" parameter. By default, they are returned as-is.
+	DropObfuscated bool
+	// Headers lists the request headers that identify the client, in order of
+	// precedence. The "for" parameters of the elements of Forwarded headers
+	// are used. For other headers, such as X-Forwarded-For and X-Real-IP, the
+	// comma-separated values are used. If TrustedProxies is nil, the first
+	// (leftmost) value is used, which the client can forge. If Headers is
+	// nil, DefaultClientHeaders is used, or DefaultTrustedClientHeaders if
+	// TrustedProxies is set. If it is empty but not nil, no headers are
+	// consulted.
+	//
+	// With TrustedProxies, Headers must only list headers that the trusted
+	// proxies overwrite or append to. A header that they pass through
+	// unchanged is entirely controlled by the client, even if it is listed
+	// after one that the proxies do set, since it takes precedence.
+	Headers []string
+	// TrustedProxies, if it is not nil, restricts client identification to
+	// requests from the listed networks. Headers of requests from other peers
+	// are ignored, since they may be forged, and the peer address is used.
+	// For requests from trusted peers, the values in the headers are walked
+	// from the last (rightmost), which was added by the peer, skipping the
+	// addresses of trusted proxies, and the first address that is not
+	// trusted is used. Values to the left of it were sent by the client or
+	// an untrusted proxy, and are ignored. If every value is trusted, the
+	// first is used. TrustedProxies must be set for Scheme to use forwarded
+	// headers.
+	TrustedProxies []*net.IPNet
+}
+
+// DefaultClientHeaders lists the headers consulted by ClientResolver when its
+// Headers and TrustedProxies fields are nil.
+var DefaultClientHeaders = []string{"Forwarded", "X-Forwarded-For"}
+
+// DefaultTrustedClientHeaders lists the headers consulted by ClientResolver
+// when its TrustedProxies field is set and its Headers field is nil. It only
+// lists X-Forwarded-For, which most proxies append to, since a client could
+// forge a Forwarded header that a proxy does not set. See
+// ClientResolver.Headers.
+var DefaultTrustedClientHeaders = []string{"X-Forwarded-For"}
+
+// ClientAddr returns the address of the client for the request, without any
+// port, brackets or quotes, so that an IP address can be parsed by
+// net.ParseIP. If a client identifier is found in the headers listed in
+// Headers, it is used. Otherwise, the address of the connection peer is
+// returned. Obfuscated identifiers are returned unchanged unless
+// DropObfuscated is set.
+func (c *ClientResolver) ClientAddr(r *Request) string {
+	host, _ := c.client(r)
+	return host
+}
+
+// ClientPort returns the port of the client for the request that corresponds
+// to ClientAddr, or an empty string if the client address has no port.
+func (c *ClientResolver) ClientPort(r *Request) string {
+	_, port := c.client(r)
+	return port
+}
+
+// Scheme returns the scheme used by the client for the request, in lowercase.
+// If the connection peer is one of TrustedProxies, the scheme reported by the
+// proxies is used: the "proto" parameter of the Forwarded element found as
+// for ClientAddr if Forwarded is listed in Headers, or the last
+// X-Forwarded-Proto value if X-Forwarded-For is listed. Otherwise,
+// Request.Scheme is returned. Unlike ClientAddr, forwarded headers are ignored
+// if TrustedProxies is nil, since a forged scheme may be mistaken for evidence
+// that a request was encrypted.
+func (c *ClientResolver) Scheme(r *Request) string {
+	if c == nil || !trustedPeer(r.PeerAddr(), c.TrustedProxies) {
+		return r.Scheme
+	}
+	for _, name := range c.headers() {
+		var proto string
+		switch http.CanonicalHeaderKey(name) {
+		case "Forwarded":
+			if elems := r.forwardedElements(); len(elems) > 0 {
+				hops := make([]string, len(elems))
+				for i, elem := range elems {
+					hops[i] = elem["for"]
+				}
+				proto = elems[trustedHop(hops, c.TrustedProxies)]["proto"]
+			}
+		case "X-Forwarded-For":
+			if values := headerValues(r.Header["X-Forwarded-Proto"]); len(values) > 0 {
+				proto = values[len(values)-1]
+			}
+		}
+		if proto != "" {
+			return strings.ToLower(proto)
+		}
+	}
+	return r.Scheme
+}
+
+// headers returns the headers that identify the client, as described for
+// Headers.
+func (c *ClientResolver) headers() []string {
+	switch {
+	case c == nil:
+		return DefaultClientHeaders
+	case c.Headers != nil:
+		return c.Headers
+	case c.TrustedProxies != nil:
+		return DefaultTrustedClientHeaders
+	}
+	return DefaultClientHeaders
+}
+
+// client returns the normalized host and port of the client for the request.
+func (c *ClientResolver) client(r *Request) (host, port string) {
+	headers := c.headers()
+	var trusted []*net.IPNet
+	if c != nil {
+		if trusted = c.TrustedProxies; trusted != nil && !trustedPeer(r.PeerAddr(), trusted) {
+			headers = nil
+		}
+	}
+	for _, name := range headers {
+		var hops []string
+		if name = http.CanonicalHeaderKey(name); name == "Forwarded" {
+			for _, elem := range r.forwardedElements() {
+				hops = append(hops, elem["for"])
+			}
+		} else {
+			hops = headerValues(r.Header[name])
+		}
+		if len(hops) == 0 {
+			continue
+		}
+		i := 0
+		if trusted != nil {
+			i = trustedHop(hops, trusted)
+		}
+		host, port = splitNode(hops[i])
+		if host == "" || c != nil && c.DropObfuscated && obfuscated(host) {
+			continue
+		}
+		return host, port
+	}
+	return r.PeerAddr(), r.RemotePort()
+}
+
+// trustedPeer reports whether the peer address is in one of the networks.
+func trustedPeer(peer string, networks []*net.IPNet) bool {
+	ip := net.ParseIP(peer)
+	if ip == nil {
+		return false
+	}
+	for _, n := range networks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// trustedHop returns the index of the hop that identifies the client among
+// hops, the node identifiers appended by each proxy, given that the last was
+// appended by a trusted peer. Hops are walked from the last, skipping those
+// that are trusted proxies, since the hop before a trusted proxy was appended
+// by that proxy. If every hop is trusted, 0 is returned.
+func trustedHop(hops []string, trusted []*net.IPNet) int {
+	i := len(hops) - 1
+	for ; i > 0; i-- {
+		if host, _ := splitNode(hops[i]); !trustedPeer(host, trusted) {
+			break
+		}
+	}
+	return i
+}
+
+// splitNode splits a node identifier from a Forwarded or X-Forwarded-For
+// header, e.g. "1.2.3.4:80", `"[2001:db8::1]:4711"` or "_hidden", into a host
+// and port. Surrounding quotes and the brackets around IPv6 addresses are
+// removed.
+func splitNode(id string) (host, port string) {
+	id = strings.TrimSpace(id)
+	if len(id) >= 2 && id[0] == '"' && id[len(id)-1] == '"' {
+		id = id[1 : len(id)-1]
+	}
+	if strings.HasPrefix(id, "[") {
+		i := strings.IndexByte(id, ']')
+		if i < 0 {
+			return id[1:], ""
+		}
+		host, id = id[1:i], id[i+1:]
+		if strings.HasPrefix(id, ":") {
+			port = id[1:]
+		}
+		return host, port
+	}
+	// An address with more than one colon is an IPv6 address without a port.
+	if i := strings.IndexByte(id, ':'); i >= 0 && strings.LastIndexByte(id, ':') == i {
+		return id[:i], id[i+1:]
+	}
+	return id, ""
+}
+
+// obfuscated reports whether a node name is an obfuscated identifier or
+// "unknown", as defined by RFC 7239.
+func obfuscated(host string) bool {
+	return strings.HasPrefix(host, "_") || strings.EqualFold(host, "unknown")
+}
+
+// HostLookup resolves client IP addresses to host names with reverse DNS
+// lookups, for the %h directive. Results are cached. It is only used if it is
+// set as Formatter.HostLookup, which is nil by default, as with Apache's
+// HostnameLookups directive. Since lookups happen while formatting, which
+// usually happens before LoggingHandler.ServeHTTP returns, a slow DNS server
+// adds up to Timeout to the latency of requests whose results are not cached.
+// A HostLookup is safe for concurrent use; its fields must not be modified
+// after it is first used.
+type HostLookup struct {
+	// Resolver performs the lookups. If it is nil, net.DefaultResolver is
+	// used.
+	Resolver *net.Resolver
+	// Timeout limits the duration of each lookup. If it is zero,
+	// DefaultHostLookupTimeout is used.
+	Timeout time.Duration
+	// TTL is how long results, including failed lookups, are cached. If it
+	// is zero, DefaultHostLookupTTL is used.
+	TTL time.Duration
+	// MaxEntries limits the number of cached results. If it is zero,
+	// DefaultHostLookupEntries is used.
+	MaxEntries int
+
+	mu    sync.Mutex
+	cache map[string]hostEntry
+}
+
+// Defaults for the fields of HostLookup.
+const (
+	DefaultHostLookupTimeout = 500 * time.Millisecond
+	DefaultHostLookupTTL     = 5 * time.Minute
+	DefaultHostLookupEntries = 1024
+)
+
+type hostEntry struct {
+	name    string
+	expires time.Time
+}
+
+// Lookup returns the host name of the IP address addr, without a trailing dot.
+// If addr is not an IP address or the lookup fails, addr is returned.
+func (h *HostLookup) Lookup(addr string) string {
+	if net.ParseIP(addr) == nil {
+		return addr
+	}
+	now := time.Now()
+	h.mu.Lock()
+	e, ok := h.cache[addr]
+	h.mu.Unlock()
+	if ok && now.Before(e.expires) {
+		return e.name
+	}
+	name := h.lookup(addr)
+	ttl := h.TTL
+	if ttl == 0 {
+		ttl = DefaultHostLookupTTL
+	}
+	max := h.MaxEntries
+	if max == 0 {
+		max = DefaultHostLookupEntries
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.cache == nil {
+		h.cache = make(map[string]hostEntry)
+	}
+	if len(h.cache) >= max {
+		for k, e := range h.cache {
+			if now.After(e.expires) {
+				delete(h.cache, k)
+			}
+		}
+		// If no entries have expired, evict an arbitrary one.
+		for k := range h.cache {
+			if len(h.cache) < max {
+				break
+			}
+			delete(h.cache, k)
+		}
+	}
+	h.cache[addr] = hostEntry{name: name, expires: now.Add(ttl)}
+	return name
+}
+
+// lookup performs a reverse lookup of addr, returning addr if it fails.
+func (h *HostLookup) lookup(addr string) string {
+	resolver := h.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	timeout := h.Timeout
+	if timeout == 0 {
+		timeout = DefaultHostLookupTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	names, err := resolver.LookupAddr(ctx, addr)
+	if err != nil || len(names) == 0 {
+		return addr
+	}
+	return strings.TrimSuffix(names[0], ".")
+}
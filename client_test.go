@@ -0,0 +1,121 @@
+package httplog
+
+import (
+	"net"
+	"net/http"
+	"testing"
+)
+
+func TestClientResolverTrustedProxies(t *testing.T) {
+	_, proxies, _ := net.ParseCIDR("10.0.0.0/8")
+	c := &ClientResolver{
+		Headers:        []string{"Forwarded", "X-Forwarded-For"},
+		TrustedProxies: []*net.IPNet{proxies},
+	}
+	for _, test := range []struct {
+		remote, header, value, want string
+	}{
+		// Forged values to the left of the address appended by a trusted
+		// proxy are ignored.
+		{"10.0.0.1:1234", "X-Forwarded-For", "6.6.6.6, 203.0.113.7", "203.0.113.7"},
+		{"10.0.0.1:1234", "X-Forwarded-For", "6.6.6.6, 203.0.113.7, 10.0.0.2", "203.0.113.7"},
+		{"10.0.0.1:1234", "Forwarded", "for=6.6.6.6, for=203.0.113.7", "203.0.113.7"},
+		{"10.0.0.1:1234", "Forwarded", `for=6.6.6.6, for="[2001:db8::1]:80", for=10.0.0.2`, "2001:db8::1"},
+		// If every hop is trusted, the first is used.
+		{"10.0.0.1:1234", "X-Forwarded-For", "10.0.0.3, 10.0.0.2", "10.0.0.3"},
+		// Headers from untrusted peers are ignored.
+		{"192.0.2.1:1234", "X-Forwarded-For", "6.6.6.6", "192.0.2.1"},
+	} {
+		r := &Request{RemoteAddr: test.remote, Header: http.Header{test.header: {test.value}}}
+		if got := c.ClientAddr(r); got != test.want {
+			t.Errorf("%s: %s from %s: got %q, want %q", test.header, test.value, test.remote, got, test.want)
+		}
+	}
+}
+
+func TestClientResolverUntrusted(t *testing.T) {
+	// Without TrustedProxies, the first value is used.
+	r := &Request{RemoteAddr: "10.0.0.1:1234", Header: http.Header{"X-Forwarded-For": {"6.6.6.6, 203.0.113.7"}}}
+	if got := (&ClientResolver{}).ClientAddr(r); got != "6.6.6.6" {
+		t.Errorf("got %q, want %q", got, "6.6.6.6")
+	}
+}
+
+func TestClientResolverForgedForwarded(t *testing.T) {
+	// With the default Headers, a Forwarded header forged by the client and
+	// passed through by a proxy that only appends to X-Forwarded-For is
+	// ignored.
+	_, proxies, _ := net.ParseCIDR("10.0.0.0/8")
+	c := &ClientResolver{TrustedProxies: []*net.IPNet{proxies}}
+	r := &Request{
+		RemoteAddr: "10.0.0.1:1234",
+		Scheme:     "http",
+		Header: http.Header{
+			"Forwarded":       {"for=6.6.6.6;proto=https"},
+			"X-Forwarded-For": {"203.0.113.7"},
+		},
+	}
+	if addr, scheme := c.ClientAddr(r), c.Scheme(r); addr != "203.0.113.7" || scheme != "http" {
+		t.Errorf("got %q, %q, want %q, %q", addr, scheme, "203.0.113.7", "http")
+	}
+	if scheme := r.SchemeTrusted(c.TrustedProxies); scheme != "http" {
+		t.Errorf("SchemeTrusted returned %q, want %q", scheme, "http")
+	}
+}
+
+func TestClientResolverScheme(t *testing.T) {
+	_, proxies, _ := net.ParseCIDR("10.0.0.0/8")
+	c := &ClientResolver{
+		Headers:        []string{"Forwarded", "X-Forwarded-For"},
+		TrustedProxies: []*net.IPNet{proxies},
+	}
+	for _, test := range []struct {
+		remote string
+		header http.Header
+		want   string
+	}{
+		// The proto of the element added by the trusted peer is used, not a
+		// forged one to its left.
+		{"10.0.0.1:1234", http.Header{"Forwarded": {"for=6.6.6.6;proto=https, for=9.9.9.9;proto=http"}}, "http"},
+		{"10.0.0.1:1234", http.Header{"Forwarded": {"for=9.9.9.9;proto=HTTPS"}}, "https"},
+		{"10.0.0.1:1234", http.Header{"Forwarded": {"for=9.9.9.9;proto=https, for=10.0.0.2;proto=http"}}, "https"},
+		{"10.0.0.1:1234", http.Header{"X-Forwarded-Proto": {"https, http"}}, "http"},
+		{"10.0.0.1:1234", http.Header{"X-Forwarded-Proto": {"https"}}, "https"},
+		{"192.0.2.1:1234", http.Header{"X-Forwarded-Proto": {"https"}}, "http"},
+	} {
+		r := &Request{RemoteAddr: test.remote, Header: test.header, Scheme: "http"}
+		if got := c.Scheme(r); got != test.want {
+			t.Errorf("%v from %s: got %q, want %q", test.header, test.remote, got, test.want)
+		}
+	}
+}
+
+func TestClientResolverNodes(t *testing.T) {
+	for _, test := range []struct {
+		header, value      string
+		drop               bool
+		wantAddr, wantPort string
+	}{
+		{"X-Forwarded-For", "203.0.113.7", false, "203.0.113.7", ""},
+		{"X-Forwarded-For", "203.0.113.7:4711", false, "203.0.113.7", "4711"},
+		{"X-Forwarded-For", "[2001:db8::1]:4711", false, "2001:db8::1", "4711"},
+		{"X-Forwarded-For", `"[2001:db8::1]:4711"`, false, "2001:db8::1", "4711"},
+		{"X-Forwarded-For", "2001:db8::1", false, "2001:db8::1", ""},
+		{"Forwarded", "for=203.0.113.7", false, "203.0.113.7", ""},
+		{"Forwarded", "for=203.0.113.7:4711", false, "203.0.113.7", "4711"},
+		{"Forwarded", `for="[2001:db8::1]:4711"`, false, "2001:db8::1", "4711"},
+		{"Forwarded", `for="[2001:db8::1]"`, false, "2001:db8::1", ""},
+		{"Forwarded", "for=_hidden", false, "_hidden", ""},
+		{"Forwarded", "for=unknown", false, "unknown", ""},
+		// Dropped identifiers fall back to the peer address.
+		{"Forwarded", "for=_hidden", true, "192.0.2.1", "1234"},
+		{"Forwarded", "for=unknown", true, "192.0.2.1", "1234"},
+	} {
+		c := &ClientResolver{DropObfuscated: test.drop}
+		r := &Request{RemoteAddr: "192.0.2.1:1234", Header: http.Header{test.header: {test.value}}}
+		if addr, port := c.ClientAddr(r), c.ClientPort(r); addr != test.wantAddr || port != test.wantPort {
+			t.Errorf("%s: %s (drop %t): got %q, %q, want %q, %q",
+				test.header, test.value, test.drop, addr, port, test.wantAddr, test.wantPort)
+		}
+	}
+}
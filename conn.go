@@ -0,0 +1,32 @@
+package httplog
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+)
+
+// ConnContext can be assigned to http.Server.ConnContext in order to count the
+// requests received on each connection. Without it, Request.ConnRequests is
+// always zero.
+func ConnContext(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, connKey{}, new(connState))
+}
+
+type connKey struct{}
+
+// connState holds per-connection information stored by ConnContext.
+type connState struct {
+	requests int64
+}
+
+// nextConnRequest increments the number of requests received on the
+// connection associated with ctx and returns the result, or 0 if ConnContext
+// was not used.
+func nextConnRequest(ctx context.Context) int64 {
+	c, ok := ctx.Value(connKey{}).(*connState)
+	if !ok {
+		return 0
+	}
+	return atomic.AddInt64(&c.requests, 1)
+}
@@ -0,0 +1,133 @@
+package httplog
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// recordKey is the context key for the *Record of the request being processed
+// by a LoggingHandler.
+type recordKey struct{}
+
+// FromContext returns the *Record of the request with context ctx, or nil if
+// the request is not being processed by a LoggingHandler. Handlers may use it
+// to read the record or to set its fields, such as RequestID, Tag, Notes or
+// Err, before they return. Fields that describe the response, such as Status
+// and Size, as well as EndTime, Duration, Aborted, Pattern, AllocDelta,
+// RequestBodySize and Request.Trailer, are set after the handler returns, so
+// changes to them are overwritten. The record must not be used after the
+// handler returns, and concurrent access from multiple goroutines must be
+// synchronized by the handler.
+func FromContext(ctx context.Context) *Record {
+	r, _ := ctx.Value(recordKey{}).(*Record)
+	return r
+}
+
+// MarkHandlerStart records the current time as the time that the handler for
+// the request started, in order to distinguish time spent in middleware from
+// time spent in the handler. It should be called at the start of the innermost
+// handler, with the request's context. It has no effect if the request is not
+// being processed by a LoggingHandler.
+func MarkHandlerStart(ctx context.Context) {
+	if r := FromContext(ctx); r != nil {
+		r.HandlerStart = time.Now()
+	}
+}
+
+// RequestID returns the ID of the request with context ctx, if
+// LoggingHandler.GenerateRequestID is set. Otherwise, it returns an empty
+// string.
+func RequestID(ctx context.Context) string {
+	if r := FromContext(ctx); r != nil {
+		return r.RequestID
+	}
+	return ""
+}
+
+// SetTag sets the tag of the request with context ctx, which is logged by the
+// %{tag}x directive. If LoggingHandler.TagSeparator is set, s is appended to
+// any existing tag. Otherwise, it replaces the existing tag. It has no effect
+// if the request is not being processed by a LoggingHandler.
+func SetTag(ctx context.Context, s string) {
+	r := FromContext(ctx)
+	switch {
+	case r == nil:
+	case r.Tag != "" && r.tagSep != "":
+		r.Tag += r.tagSep + s
+	default:
+		r.Tag = s
+	}
+}
+
+// SetUser sets the user name of the request with context ctx, which is logged
+// by the %u directive. It allows middleware that authenticates requests by
+// other means than basic authentication, such as with tokens or sessions, to
+// log the authenticated user. By default, the user name is taken from basic
+// authentication credentials. It has no effect if the request is not being
+// processed by a LoggingHandler.
+func SetUser(ctx context.Context, name string) {
+	if r := FromContext(ctx); r != nil {
+		r.User = name
+	}
+}
+
+// SetNote sets the note key of the request with context ctx to val, which is
+// logged by the %{key}n directive. Notes are a way for middleware and handlers
+// to log application-specific values. It has no effect if the request is not
+// being processed by a LoggingHandler. Like the request, notes must not be set
+// concurrently from multiple goroutines.
+func SetNote(ctx context.Context, key, val string) {
+	r := FromContext(ctx)
+	if r == nil {
+		return
+	}
+	if r.Notes == nil {
+		r.Notes = make(map[string]string)
+	}
+	r.Notes[key] = val
+}
+
+// Notes returns the notes of the request with context ctx, which may be nil if
+// none were set or the request is not being processed by a LoggingHandler. The
+// map must not be modified; use SetNote instead.
+func Notes(ctx context.Context) map[string]string {
+	if r := FromContext(ctx); r != nil {
+		return r.Notes
+	}
+	return nil
+}
+
+var contextKeys = struct {
+	sync.RWMutex
+	m map[string]interface{}
+}{m: make(map[string]interface{})}
+
+// RegisterContextKey registers a context key under name, so that the value for
+// key in the request context can be logged with the %{name}v directive. Values
+// must be strings or implement fmt.Stringer. It is safe to call concurrently,
+// but is usually called during initialization.
+func RegisterContextKey(name string, key interface{}) {
+	contextKeys.Lock()
+	contextKeys.m[name] = key
+	contextKeys.Unlock()
+}
+
+// contextValue returns the string value from ctx for the key registered as
+// name, or an empty string.
+func contextValue(ctx context.Context, name string) string {
+	contextKeys.RLock()
+	key, ok := contextKeys.m[name]
+	contextKeys.RUnlock()
+	if !ok || ctx == nil {
+		return ""
+	}
+	switch v := ctx.Value(key).(type) {
+	case string:
+		return v
+	case fmt.Stringer:
+		return v.String()
+	}
+	return ""
+}
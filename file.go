@@ -0,0 +1,149 @@
+package httplog
+
+import (
+	"bufio"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultFlushInterval is the interval at which a ReopenableWriter flushes
+// buffered writes if no interval is given.
+const DefaultFlushInterval = time.Second
+
+// fileBufferSize is the size of a ReopenableWriter's buffer.
+const fileBufferSize = 64 << 10
+
+// ReopenableWriter writes to a file that can be reopened, so that logs can be
+// rotated by tools such as logrotate without restarting the server: after the
+// file is renamed, Reopen is called, typically on SIGHUP, to create a new file
+// at the original path. Writes are buffered and flushed periodically and when
+// the buffer is full, so a line may not appear in the file until the flush
+// interval has elapsed. It is safe for concurrent use. Close should be called
+// on shutdown to flush any buffered writes.
+type ReopenableWriter struct {
+	path     string
+	interval time.Duration
+	done     chan struct{}
+	exited   chan struct{}
+
+	mu     sync.Mutex
+	file   *os.File
+	buf    *bufio.Writer
+	closed bool
+}
+
+// OpenReopenableWriter opens the file at path for appending, creating it if
+// necessary, and returns a ReopenableWriter that writes to it. Buffered
+// writes are flushed every interval, or every DefaultFlushInterval if
+// interval is not positive.
+func OpenReopenableWriter(path string, interval time.Duration) (*ReopenableWriter, error) {
+	file, err := openLogFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if interval <= 0 {
+		interval = DefaultFlushInterval
+	}
+	w := &ReopenableWriter{
+		path:     path,
+		interval: interval,
+		done:     make(chan struct{}),
+		exited:   make(chan struct{}),
+		file:     file,
+		buf:      bufio.NewWriterSize(file, fileBufferSize),
+	}
+	go w.run()
+	return w, nil
+}
+
+// openLogFile opens the file at path for appending, creating it if necessary.
+func openLogFile(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+}
+
+// run flushes w periodically until it is closed.
+func (w *ReopenableWriter) run() {
+	defer close(w.exited)
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			w.Flush()
+		case <-w.done:
+			return
+		}
+	}
+}
+
+// Write writes p to the buffer. It returns os.ErrClosed if w has been closed.
+func (w *ReopenableWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return 0, os.ErrClosed
+	}
+	return w.buf.Write(p)
+}
+
+// Flush writes any buffered data to the file.
+func (w *ReopenableWriter) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return os.ErrClosed
+	}
+	return w.buf.Flush()
+}
+
+// Reopen flushes any buffered data, closes the file and opens the path again,
+// creating a new file if the old one was renamed or removed. If the path
+// cannot be opened, w continues writing to the old file and the error is
+// returned.
+func (w *ReopenableWriter) Reopen() error {
+	file, err := openLogFile(w.path)
+	if err != nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		file.Close()
+		return os.ErrClosed
+	}
+	err = w.buf.Flush()
+	if cerr := w.file.Close(); err == nil {
+		err = cerr
+	}
+	w.file = file
+	w.buf.Reset(file)
+	return err
+}
+
+// Close flushes any buffered data and closes the file. Writes after Close
+// return os.ErrClosed.
+func (w *ReopenableWriter) Close() error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return os.ErrClosed
+	}
+	w.closed = true
+	err := w.buf.Flush()
+	if cerr := w.file.Close(); err == nil {
+		err = cerr
+	}
+	w.mu.Unlock()
+	close(w.done)
+	<-w.exited
+	return err
+}
+
+// LogFn returns a LogFn that writes each record to w, formatted with format
+// and followed by a newline. Write errors are ignored.
+func (w *ReopenableWriter) LogFn(format string) LogFn {
+	return func(record *Record) {
+		w.Write([]byte(record.Format(format) + "\n"))
+	}
+}
@@ -0,0 +1,99 @@
+package httplog
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// readFile returns the contents of the file at path.
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestReopenableWriterReopen(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "access.log")
+	w, err := OpenReopenableWriter(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	w.Write([]byte("one\n"))
+	// As logrotate does, rename the file and then reopen the writer.
+	rotated := filepath.Join(dir, "access.log.1")
+	if err := os.Rename(path, rotated); err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("two\n"))
+	if err := w.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("three\n"))
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := readFile(t, rotated), "one\ntwo\n"; got != want {
+		t.Errorf("rotated file contains %q, want %q", got, want)
+	}
+	if got, want := readFile(t, path), "three\n"; got != want {
+		t.Errorf("new file contains %q, want %q", got, want)
+	}
+}
+
+func TestReopenableWriterClose(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	w, err := OpenReopenableWriter(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.LogFn("%m %U")(completedRecord())
+	if got := readFile(t, path); got != "" {
+		t.Errorf("file contains %q before Close, want it buffered", got)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := readFile(t, path), "GET /index.html\n"; got != want {
+		t.Errorf("file contains %q after Close, want %q", got, want)
+	}
+	if _, err := w.Write([]byte("late\n")); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("Write after Close returned %v, want %v", err, os.ErrClosed)
+	}
+	if err := w.Flush(); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("Flush after Close returned %v, want %v", err, os.ErrClosed)
+	}
+	if err := w.Reopen(); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("Reopen after Close returned %v, want %v", err, os.ErrClosed)
+	}
+	if err := w.Close(); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("second Close returned %v, want %v", err, os.ErrClosed)
+	}
+	if got, want := readFile(t, path), "GET /index.html\n"; got != want {
+		t.Errorf("file contains %q after writes following Close, want %q", got, want)
+	}
+}
+
+func TestReopenableWriterFlushInterval(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	w, err := OpenReopenableWriter(path, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	w.Write([]byte("line\n"))
+	deadline := time.Now().Add(5 * time.Second)
+	for readFile(t, path) != "line\n" {
+		if time.Now().After(deadline) {
+			t.Fatal("buffered write was not flushed periodically")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
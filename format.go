@@ -0,0 +1,899 @@
+package httplog
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Formatter formats records according to format strings. Its fields are
+// options that control how values are formatted. The zero value is ready to
+// use. A Formatter must not be modified while it is in use, or copied after
+// it is first used.
+type Formatter struct {
+	// NoEscape disables escaping of values from requests and responses. By
+	// default, as with Apache's mod_log_config, '"' and '\' are escaped with
+	// a backslash, and control characters are written as "\n", "\r", "\t"
+	// or "\xNN", so that clients cannot forge log entries. NoEscape should
+	// only be set if clients are trusted.
+	NoEscape bool
+	// Directives maps names to functions that format custom directives, in
+	// the same manner as RegisterDirective. They take precedence over
+	// registered directives, and apply only to this Formatter.
+	Directives map[string]func(*Record) string
+	// Client determines the client address logged by %a and
+	// %{client_port}x. If it is nil, the default options are used.
+	Client *ClientResolver
+	// HostLookup, if it is not nil, is used to resolve the client address to
+	// a host name for %h. See HostLookup for the latency this may add.
+	HostLookup *HostLookup
+	// CacheEnv causes environment variables logged by %{NAME}e to be read
+	// only once, the first time they are logged, rather than for each
+	// record.
+	CacheEnv bool
+	// FoldCookieNames causes %{NAME}C to match cookie names
+	// case-insensitively, logging the first cookie whose name matches. By
+	// default, cookie names are case-sensitive, as specified by RFC 6265.
+	FoldCookieNames bool
+	// HeaderJoin is the separator written between the values of a header or
+	// trailer that appears more than once, for %{NAME}i, %{NAME}o,
+	// %{NAME}^ti and %{NAME}^to. If it is empty, "," is used, as with
+	// Apache. Multiple Cookie headers are always joined with "; ", since
+	// cookie values may contain commas. Multiple Set-Cookie headers are
+	// always joined with a newline, which is logged as "\n" unless NoEscape
+	// is set, since their attributes may contain both commas and semicolons,
+	// as in "Expires=Wed, 21 Oct 2015 07:28:00 GMT; Path=/".
+	HeaderJoin string
+	// RedactHeaders lists request and response headers whose values are
+	// replaced with RedactedValue by %{NAME}i, %{NAME}o, %{NAME}^ti and
+	// %{NAME}^to, such as "Authorization" and "Cookie". Names are
+	// case-insensitive. If "Cookie" is listed, %{NAME}C is also redacted.
+	RedactHeaders []string
+	// RedactQuery lists query parameters whose values are replaced with
+	// RedactedValue by %q and %r, such as "token". Names are compared after
+	// decoding, and are case-insensitive.
+	RedactQuery []string
+	// MaxLength, if it is positive, is the maximum number of bytes logged for
+	// each value from a request or response, such as a header for %{NAME}i
+	// or the request target for %r, %U and %q, so that clients cannot bloat
+	// the log with enormous values. Longer values are truncated, at a UTF-8
+	// character boundary, and followed by Ellipsis. The limit applies before
+	// escaping.
+	MaxLength int
+	// Location, if it is not nil, is the time zone in which times are logged
+	// by %t, %{FORMAT}t and %{clientcert_expiry}x, such as time.UTC. By
+	// default, the request times are logged in the local time zone.
+	Location *time.Location
+	// GRPCStatusTrailer is the response trailer logged by %{grpc-status}x. If
+	// it is empty, "Grpc-Status" is used.
+	GRPCStatusTrailer string
+
+	env sync.Map // cached environment variables for CacheEnv
+}
+
+// DefaultFormatter is the Formatter used by Record.Format.
+var DefaultFormatter = new(Formatter)
+
+// RedactedValue replaces the values of headers and query parameters listed in
+// Formatter.RedactHeaders and Formatter.RedactQuery.
+const RedactedValue = "***"
+
+// Ellipsis follows values truncated because of Formatter.MaxLength.
+const Ellipsis = "..."
+
+// pid is the process ID, for %P.
+var pid = strconv.Itoa(os.Getpid())
+
+var directives = struct {
+	sync.RWMutex
+	m map[string]func(*Record) string
+}{m: make(map[string]func(*Record) string)}
+
+// RegisterDirective registers a function that formats a custom directive. A
+// directive with a single-character name, such as "Z", may be used as "%Z";
+// directives with any name may be used as "%{NAME}X". Built-in directives take
+// precedence, so a single-character name that is already a directive is only
+// usable with "%{NAME}X". The value returned by fn is escaped, and "-" is
+// logged if it is empty. Registering a nil fn removes the directive.
+//
+// RegisterDirective is safe to call concurrently with formatting, though
+// directives are usually registered during initialization. fn may be called
+// concurrently from multiple goroutines.
+func RegisterDirective(name string, fn func(*Record) string) {
+	directives.Lock()
+	defer directives.Unlock()
+	if fn == nil {
+		delete(directives.m, name)
+	} else {
+		directives.m[name] = fn
+	}
+}
+
+// directive returns the function for the custom directive name, or nil if
+// there is none.
+func (f *Formatter) directive(name string) func(*Record) string {
+	if fn, ok := f.Directives[name]; ok {
+		return fn
+	}
+	directives.RLock()
+	defer directives.RUnlock()
+	return directives.m[name]
+}
+
+// Format formats the record according to a format string. The supported
+// directives are described in the documentation for Record.Format.
+func (f *Formatter) Format(r *Record, format string) string {
+	return string(f.AppendFormat(nil, r, format))
+}
+
+// AppendFormat is like Format, but appends the formatted record to dst and
+// returns the extended buffer, so that a buffer can be reused.
+func (f *Formatter) AppendFormat(dst []byte, r *Record, format string) []byte {
+	b := buffer(dst)
+	var client lazyClient
+	for i, l := 0, len(format); i < l; i++ {
+		switch format[i] {
+		case '%':
+			if i++; i == l {
+				b.WriteByte('%')
+				return b
+			}
+			switch format[i] {
+			case '%':
+				b.WriteByte('%')
+			case '<', '>':
+				if i+1 == l || format[i+1] != 's' {
+					b.WriteByte('%')
+					b.WriteByte(format[i])
+					break
+				}
+				i++
+				writeStatus(&b, r.Status)
+			case 'B':
+				b.WriteString(strconv.FormatInt(r.Size, 10))
+			case 'b':
+				if r.Size == 0 {
+					b.WriteByte('-')
+				} else {
+					b.WriteString(strconv.FormatInt(r.Size, 10))
+				}
+			case 'D':
+				b.WriteString(strconv.FormatInt(r.Duration.Microseconds(), 10))
+			case 'F':
+				if ttfb, ok := r.TimeToFirstByte(); ok {
+					b.WriteString(strconv.FormatInt(ttfb.Microseconds(), 10))
+				} else {
+					b.WriteByte('-')
+				}
+			case 'H':
+				f.writeString(&b, r.Proto)
+			case 'I':
+				n := r.Request.HeaderSize() + r.RequestBodySize
+				b.WriteString(strconv.FormatInt(n, 10))
+			case 'L':
+				f.writeValue(&b, r.RequestID)
+			case 'O':
+				n := r.Response.HeaderSize() + r.Size
+				b.WriteString(strconv.FormatInt(n, 10))
+			case 'P':
+				b.WriteString(pid)
+			case 'R':
+				f.writeValue(&b, r.Pattern)
+			case 'T':
+				s := r.Duration.Seconds()
+				b.WriteString(strconv.FormatFloat(s, 'f', -1, 64))
+			case 'U':
+				f.writeString(&b, r.RequestPath())
+			case 'X':
+				b.WriteByte(r.ConnectionStatus())
+			case 'a':
+				addr, _ := client.get(f, r)
+				f.writeString(&b, addr)
+			case 'h':
+				addr, _ := client.get(f, r)
+				if f.HostLookup != nil {
+					addr = f.HostLookup.Lookup(addr)
+				}
+				f.writeString(&b, addr)
+			case 'k':
+				var n int64
+				if r.ConnRequests > 0 {
+					n = r.ConnRequests - 1
+				}
+				b.WriteString(strconv.FormatInt(n, 10))
+			case 'l':
+				b.WriteByte('-')
+			case 'm':
+				f.writeString(&b, r.Method)
+			case 'p':
+				writeValue(&b, r.LocalPort())
+			case 'q':
+				if q := r.RequestQuery(); q != "" {
+					b.WriteByte('?')
+					f.writeString(&b, f.redactQuery(q))
+				}
+			case 'r':
+				f.writeString(&b, r.Method)
+				b.WriteByte(' ')
+				f.writeString(&b, f.redactTarget(r.RequestTarget()))
+				b.WriteByte(' ')
+				f.writeString(&b, r.Proto)
+			case 's':
+				writeStatus(&b, r.Status)
+			case 't':
+				b.WriteString(f.time(r.StartTime).Format("[02/Jan/2006:15:04:05 -0700]"))
+			case 'u':
+				f.writeValue(&b, r.Request.User)
+			case 'v':
+				f.writeString(&b, r.Host)
+			case '{':
+				j := i + 1
+				for ; j < l && format[j] != '}'; j++ {
+				}
+				if j == l {
+					b.WriteByte('%')
+					b.WriteString(format[i:])
+					return b
+				}
+				key := format[i+1 : j]
+				j++
+				if j == l {
+					b.WriteByte('%')
+					b.WriteString(format[i:])
+					return b
+				}
+				i = j
+				switch format[j] {
+				case 'B':
+					if key != "uncompressed" {
+						b.WriteString("%{")
+						b.WriteString(key)
+						b.WriteString("}B")
+					} else if r.UncompressedSize < 0 {
+						b.WriteByte('-')
+					} else {
+						b.WriteString(strconv.FormatInt(r.UncompressedSize, 10))
+					}
+				case 'a':
+					if key == "c" {
+						writeValue(&b, r.PeerAddr())
+					} else {
+						b.WriteString("%{")
+						b.WriteString(key)
+						b.WriteString("}a")
+					}
+				case 'C':
+					if f.redactHeader("Cookie") {
+						if r.Cookie(key) != "" || f.FoldCookieNames && r.CookieFold(key) != "" {
+							b.WriteString(RedactedValue)
+						}
+					} else if f.FoldCookieNames {
+						f.writeString(&b, r.CookieFold(key))
+					} else {
+						f.writeString(&b, r.Cookie(key))
+					}
+				case 'P':
+					switch key {
+					case "pid":
+						b.WriteString(pid)
+					case "tid", "hextid":
+						// Go does not expose thread or goroutine IDs.
+						b.WriteByte('-')
+					default:
+						b.WriteString("%{")
+						b.WriteString(key)
+						b.WriteString("}P")
+					}
+				case 'T':
+					switch key {
+					case "middleware_overhead":
+						if r.HandlerStart.IsZero() {
+							b.WriteByte('-')
+							break
+						}
+						s := r.HandlerStart.Sub(r.StartTime).Seconds()
+						b.WriteString(strconv.FormatFloat(s, 'f', -1, 64))
+					case "sse_ttff":
+						if r.SSEFirstFlush.IsZero() {
+							b.WriteByte('-')
+							break
+						}
+						s := r.SSEFirstFlush.Sub(r.StartTime).Seconds()
+						b.WriteString(strconv.FormatFloat(s, 'f', -1, 64))
+					default:
+						if s, ok := formatDuration(r.Duration, key); ok {
+							b.WriteString(s)
+						} else {
+							b.WriteString("%{")
+							b.WriteString(key)
+							b.WriteString("}T")
+						}
+					}
+				case 'D':
+					if s, ok := formatDurationInt(r.Duration, key); ok {
+						b.WriteString(s)
+					} else {
+						b.WriteString("%{")
+						b.WriteString(key)
+						b.WriteString("}D")
+					}
+				case 'F':
+					ttfb, wrote := r.TimeToFirstByte()
+					if s, ok := formatDuration(ttfb, key); !ok {
+						b.WriteString("%{")
+						b.WriteString(key)
+						b.WriteString("}F")
+					} else if !wrote {
+						b.WriteByte('-')
+					} else {
+						b.WriteString(s)
+					}
+				case 'e':
+					f.writeValue(&b, f.getenv(key))
+				case 'i':
+					name := http.CanonicalHeaderKey(key)
+					if !r.omitHeader(name) {
+						f.writeString(&b, f.joinHeader(name, r.Request.Header[name]))
+					}
+				case 'n':
+					f.writeValue(&b, r.Notes[key])
+				case 'o':
+					name := http.CanonicalHeaderKey(key)
+					f.writeString(&b, f.joinHeader(name, r.Response.Header[name]))
+				case '^':
+					if j+2 >= l || format[j+1] != 't' ||
+						format[j+2] != 'i' && format[j+2] != 'o' {
+						b.WriteString("%{")
+						b.WriteString(key)
+						b.WriteByte('}')
+						b.WriteByte(format[j])
+						break
+					}
+					var values []string
+					if i += 2; format[i] == 'i' {
+						values = r.Request.Trailer[http.CanonicalHeaderKey(key)]
+					} else {
+						values = r.Response.Trailer(key)
+					}
+					f.writeValue(&b, f.joinHeader(http.CanonicalHeaderKey(key), values))
+				case 'p':
+					switch key {
+					case "local":
+						writeValue(&b, r.LocalPort())
+					case "remote":
+						writeValue(&b, r.RemotePort())
+					default:
+						b.WriteString("%{")
+						b.WriteString(key)
+						b.WriteString("}p")
+					}
+				case 't':
+					f.writeTime(&b, r, key)
+				case 'v':
+					f.writeValue(&b, contextValue(r.Context, key))
+				case 'x':
+					switch key {
+					case "aborted":
+						b.WriteString(strconv.FormatBool(r.Aborted))
+					case "alloc_delta":
+						b.WriteString(strconv.FormatUint(r.AllocDelta, 10))
+					case "client_port":
+						_, port := client.get(f, r)
+						f.writeValue(&b, port)
+					case "error":
+						if r.Err != nil {
+							f.writeValue(&b, r.Err.Error())
+						} else {
+							b.WriteByte('-')
+						}
+					case "flushed":
+						b.WriteString(strconv.FormatBool(r.Flushed))
+					case "grpc-status":
+						name := f.GRPCStatusTrailer
+						if name == "" {
+							name = "Grpc-Status"
+						}
+						f.writeValue(&b, r.GRPCStatus(name))
+					case "host":
+						f.writeValue(&b, r.ForwardedHost())
+					case "proto":
+						proto, _, _, _ := r.Forwarded()
+						f.writeValue(&b, proto)
+					case "request-id":
+						f.writeValue(&b, r.RequestID)
+					case "route":
+						f.writeValue(&b, r.Route)
+					case "scheme":
+						f.writeValue(&b, f.Client.Scheme(&r.Request))
+					case "span-id":
+						f.writeValue(&b, r.SpanID)
+					case "sse_events":
+						b.WriteString(strconv.Itoa(r.SSEEvents))
+					case "tag":
+						f.writeValue(&b, r.Tag)
+					case "trace-id":
+						f.writeValue(&b, r.TraceID)
+					case "cipher":
+						writeValue(&b, r.TLSCipher())
+					case "clientcert_days_left":
+						if t := r.ClientCertExpiry(); !t.IsZero() {
+							// Round toward negative infinity, so that an
+							// expired certificate is negative.
+							d := t.Sub(r.StartTime)
+							days := int64(d / (24 * time.Hour))
+							if d%(24*time.Hour) < 0 {
+								days--
+							}
+							b.WriteString(strconv.FormatInt(days, 10))
+						} else {
+							b.WriteByte('-')
+						}
+					case "clientcert_expiry":
+						if t := r.ClientCertExpiry(); !t.IsZero() {
+							b.WriteString(f.time(t).Format(time.RFC3339))
+						} else {
+							b.WriteByte('-')
+						}
+					case "cn":
+						if c := r.ClientCertificate(); c != nil {
+							f.writeValue(&b, c.Subject.CommonName)
+						} else {
+							b.WriteByte('-')
+						}
+					case "issuer":
+						if c := r.ClientCertificate(); c != nil {
+							f.writeValue(&b, c.Issuer.String())
+						} else {
+							b.WriteByte('-')
+						}
+					case "serial":
+						if c := r.ClientCertificate(); c != nil && c.SerialNumber != nil {
+							b.WriteString(strings.ToUpper(c.SerialNumber.Text(16)))
+						} else {
+							b.WriteByte('-')
+						}
+					case "sni":
+						f.writeValue(&b, r.ServerName())
+					case "subject":
+						f.writeValue(&b, r.TLSSubject())
+					case "version":
+						writeValue(&b, r.TLSVersion())
+					case "wh-count":
+						b.WriteString(strconv.Itoa(r.WriteHeaderCount))
+					case "written":
+						b.WriteString(strconv.FormatBool(r.Wrote))
+					default:
+						if strings.HasPrefix(key, "cc:") {
+							f.writeCacheControl(&b, r, key[3:])
+							break
+						}
+						b.WriteString("%{")
+						b.WriteString(key)
+						b.WriteString("}x")
+					}
+				case 'X':
+					if fn := f.directive(key); fn != nil {
+						f.writeValue(&b, fn(r))
+					} else {
+						b.WriteString("%{")
+						b.WriteString(key)
+						b.WriteString("}X")
+					}
+				default:
+					b.WriteString("%{")
+					b.WriteString(key)
+					b.WriteByte('}')
+					b.WriteByte(format[j])
+				}
+			default:
+				if fn := f.directive(format[i : i+1]); fn != nil {
+					f.writeValue(&b, fn(r))
+				} else {
+					b.WriteByte('%')
+					b.WriteByte(format[i])
+				}
+			}
+		default:
+			b.WriteByte(format[i])
+		}
+	}
+	return b
+}
+
+// lazyClient holds the client address and port of a record, which are
+// resolved at most once for each call to AppendFormat, however many times the
+// format refers to them.
+type lazyClient struct {
+	host, port string
+	resolved   bool
+}
+
+// get returns the client address and port of r as determined by f.Client.
+func (c *lazyClient) get(f *Formatter, r *Record) (host, port string) {
+	if !c.resolved {
+		c.host, c.port = f.Client.client(&r.Request)
+		c.resolved = true
+	}
+	return c.host, c.port
+}
+
+// writeTime writes the start time of r, or the end time if layout begins with
+// "end:", as for %{FORMAT}t. After the prefix is removed, the layouts "sec",
+// "msec", "usec", "msec_frac" and "usec_frac" write the time as a number, as
+// with Apache; other layouts are passed to time.Time.Format.
+func (f *Formatter) writeTime(b *buffer, r *Record, layout string) {
+	t := r.StartTime
+	if strings.HasPrefix(layout, "end:") {
+		t, layout = r.EndTime, layout[len("end:"):]
+	} else {
+		layout = strings.TrimPrefix(layout, "begin:")
+	}
+	switch layout {
+	case "sec", "msec", "usec", "msec_frac", "usec_frac":
+		writeEpoch(b, t, layout)
+	default:
+		b.WriteString(f.time(t).Format(layout))
+	}
+}
+
+// writeEpoch writes t as the number of seconds, milliseconds or microseconds
+// since the Unix epoch, or the fraction of the second in milliseconds or
+// microseconds, according to unit. It writes "-" if t is zero.
+func writeEpoch(b *buffer, t time.Time, unit string) {
+	if t.IsZero() {
+		b.WriteByte('-')
+		return
+	}
+	switch unit {
+	case "sec":
+		b.WriteString(strconv.FormatInt(t.Unix(), 10))
+	case "msec":
+		b.WriteString(strconv.FormatInt(t.UnixMilli(), 10))
+	case "usec":
+		b.WriteString(strconv.FormatInt(t.UnixMicro(), 10))
+	case "msec_frac":
+		writePadded(b, t.Nanosecond()/1e6, 3)
+	case "usec_frac":
+		writePadded(b, t.Nanosecond()/1e3, 6)
+	}
+}
+
+// writePadded writes the non-negative integer n to b, padded with leading
+// zeros to width digits.
+func writePadded(b *buffer, n, width int) {
+	s := strconv.Itoa(n)
+	for i := len(s); i < width; i++ {
+		b.WriteByte('0')
+	}
+	b.WriteString(s)
+}
+
+// time returns t in f.Location, if it is set.
+func (f *Formatter) time(t time.Time) time.Time {
+	if f.Location != nil {
+		return t.In(f.Location)
+	}
+	return t
+}
+
+// getenv returns the value of the environment variable name, which is cached
+// if f.CacheEnv is set.
+func (f *Formatter) getenv(name string) string {
+	if !f.CacheEnv {
+		return os.Getenv(name)
+	}
+	if v, ok := f.env.Load(name); ok {
+		return v.(string)
+	}
+	v := os.Getenv(name)
+	f.env.Store(name, v)
+	return v
+}
+
+// joinHeader joins the values of the header name, which must be in canonical
+// form, as described for HeaderJoin, or returns RedactedValue if the header
+// is listed in RedactHeaders.
+func (f *Formatter) joinHeader(name string, values []string) string {
+	switch {
+	case len(values) == 0:
+		return ""
+	case f.redactHeader(name):
+		return RedactedValue
+	case len(values) == 1:
+		return values[0]
+	case name == "Cookie":
+		return strings.Join(values, "; ")
+	case name == "Set-Cookie":
+		return strings.Join(values, "\n")
+	case f.HeaderJoin != "":
+		return strings.Join(values, f.HeaderJoin)
+	}
+	return strings.Join(values, ",")
+}
+
+// redactHeader reports whether the header name is listed in RedactHeaders.
+func (f *Formatter) redactHeader(name string) bool {
+	for _, h := range f.RedactHeaders {
+		if strings.EqualFold(h, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactTarget returns the request target with the values of the query
+// parameters listed in RedactQuery replaced.
+func (f *Formatter) redactTarget(target string) string {
+	if len(f.RedactQuery) == 0 {
+		return target
+	}
+	i := strings.IndexByte(target, '?')
+	if i < 0 {
+		return target
+	}
+	return target[:i+1] + f.redactQuery(target[i+1:])
+}
+
+// redactQuery returns the query with the values of the parameters listed in
+// RedactQuery replaced.
+func (f *Formatter) redactQuery(query string) string {
+	if len(f.RedactQuery) == 0 {
+		return query
+	}
+	params := strings.Split(query, "&")
+	redacted := false
+	for i, param := range params {
+		key, value, ok := strings.Cut(param, "=")
+		if !ok || value == "" {
+			continue
+		}
+		if k, err := url.QueryUnescape(key); err == nil {
+			key = k
+		}
+		for _, name := range f.RedactQuery {
+			if strings.EqualFold(name, key) {
+				params[i] = param[:len(param)-len(value)] + RedactedValue
+				redacted = true
+				break
+			}
+		}
+	}
+	if !redacted {
+		return query
+	}
+	return strings.Join(params, "&")
+}
+
+// writeString writes s to b, escaping it unless f.NoEscape is set, and
+// truncating it if it is longer than f.MaxLength.
+func (f *Formatter) writeString(b *buffer, s string) {
+	truncated := false
+	if f.MaxLength > 0 && len(s) > f.MaxLength {
+		n := f.MaxLength
+		for n > 0 && !utf8.RuneStart(s[n]) {
+			n--
+		}
+		s, truncated = s[:n], true
+	}
+	if f.NoEscape {
+		b.WriteString(s)
+	} else {
+		writeEscaped(b, s)
+	}
+	if truncated {
+		b.WriteString(Ellipsis)
+	}
+}
+
+// writeValue writes s to b as with writeString, or "-" if s is empty.
+func (f *Formatter) writeValue(b *buffer, s string) {
+	if s == "" {
+		b.WriteByte('-')
+	} else {
+		f.writeString(b, s)
+	}
+}
+
+// writeCacheControl writes the value of the Cache-Control request directive
+// name to b.
+func (f *Formatter) writeCacheControl(b *buffer, r *Record, name string) {
+	name = strings.ToLower(name)
+	v, ok := r.CacheControl()[name]
+	switch {
+	case ok && v != "":
+		f.writeString(b, v)
+	case ok:
+		b.WriteString("true")
+	default:
+		switch name {
+		case "max-age", "max-stale", "min-fresh", "s-maxage",
+			"stale-if-error", "stale-while-revalidate":
+			b.WriteByte('-')
+		default:
+			b.WriteString("false")
+		}
+	}
+}
+
+// formatDuration formats d in the given unit, as for the "%{UNIT}T" directive.
+// It returns false if the unit is unknown.
+func formatDuration(d time.Duration, unit string) (string, bool) {
+	var f float64
+	switch unit {
+	case "ns":
+		return strconv.FormatInt(d.Nanoseconds(), 10), true
+	case "us":
+		f = float64(d) / float64(time.Microsecond)
+	case "ms":
+		f = float64(d) / float64(time.Millisecond)
+	case "s":
+		f = d.Seconds()
+	case "min":
+		f = d.Minutes()
+	default:
+		return "", false
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64), true
+}
+
+// formatDurationInt formats d as an integer number of the given unit,
+// truncated toward zero, as for the "%{UNIT}D" directive. It returns false if
+// the unit is unknown.
+func formatDurationInt(d time.Duration, unit string) (string, bool) {
+	var u time.Duration
+	switch unit {
+	case "ns":
+		u = time.Nanosecond
+	case "us":
+		u = time.Microsecond
+	case "ms":
+		u = time.Millisecond
+	case "s":
+		u = time.Second
+	case "min":
+		u = time.Minute
+	default:
+		return "", false
+	}
+	return strconv.FormatInt(int64(d/u), 10), true
+}
+
+// buffer is a byte slice with the methods of strings.Builder used to format
+// records.
+type buffer []byte
+
+func (b *buffer) WriteByte(c byte) error {
+	*b = append(*b, c)
+	return nil
+}
+
+func (b *buffer) WriteString(s string) (int, error) {
+	*b = append(*b, s...)
+	return len(s), nil
+}
+
+// writeStatus writes the status code to b, or "-" if it is 0, such as when the
+// connection was hijacked.
+func writeStatus(b *buffer, status int) {
+	if status == 0 {
+		b.WriteByte('-')
+	} else {
+		b.WriteString(strconv.Itoa(status))
+	}
+}
+
+// writeValue writes s to b, or "-" if s is empty. It is used for values that
+// do not need escaping.
+func writeValue(b *buffer, s string) {
+	if s == "" {
+		b.WriteByte('-')
+	} else {
+		b.WriteString(s)
+	}
+}
+
+// writeEscaped writes s to b, escaping characters in the same manner as
+// Apache's mod_log_config.
+func writeEscaped(b *buffer, s string) {
+	i := 0
+	for ; i < len(s) && !needsEscape(s[i]); i++ {
+	}
+	if i == len(s) {
+		b.WriteString(s)
+		return
+	}
+	const hex = "0123456789abcdef"
+	b.WriteString(s[:i])
+	for ; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c == '\n':
+			b.WriteString(`\n`)
+		case c == '\r':
+			b.WriteString(`\r`)
+		case c == '\t':
+			b.WriteString(`\t`)
+		case needsEscape(c):
+			b.WriteString(`\x`)
+			b.WriteByte(hex[c>>4])
+			b.WriteByte(hex[c&0xf])
+		default:
+			b.WriteByte(c)
+		}
+	}
+}
+
+func needsEscape(c byte) bool {
+	return c < ' ' || c == 0x7f || c == '"' || c == '\\'
+}
+
+// VerboseLogFn returns a LogFn that writes each record to w as a multi-line
+// block for debugging: the request line, the Host and all request headers,
+// the response status line and all response headers, followed by an empty
+// line. Headers are sorted by name, and each value is written on its own line.
+// Values are escaped, truncated and redacted according to f, so headers listed
+// in RedactHeaders stay masked. Writes are serialized, and write errors are
+// ignored.
+func (f *Formatter) VerboseLogFn(w io.Writer) LogFn {
+	var mu sync.Mutex
+	return func(record *Record) {
+		var b buffer
+		f.writeString(&b, record.Method)
+		b.WriteByte(' ')
+		f.writeString(&b, f.redactTarget(record.RequestTarget()))
+		b.WriteByte(' ')
+		f.writeString(&b, record.Proto)
+		b.WriteByte('\n')
+		if record.Host != "" {
+			b.WriteString("Host: ")
+			f.writeString(&b, record.Host)
+			b.WriteByte('\n')
+		}
+		f.writeHeaders(&b, record.Request.Header)
+		f.writeString(&b, record.Proto)
+		b.WriteByte(' ')
+		writeStatus(&b, record.Status)
+		if text := http.StatusText(record.Status); text != "" {
+			b.WriteByte(' ')
+			b.WriteString(text)
+		}
+		b.WriteByte('\n')
+		f.writeHeaders(&b, record.Response.Header)
+		b.WriteByte('\n')
+		mu.Lock()
+		w.Write(b)
+		mu.Unlock()
+	}
+}
+
+// writeHeaders writes each value of header to b as a "Name: value" line,
+// sorted by name.
+func (f *Formatter) writeHeaders(b *buffer, header http.Header) {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		redact := f.redactHeader(name)
+		for _, value := range header[name] {
+			f.writeString(b, name)
+			b.WriteString(": ")
+			if redact {
+				b.WriteString(RedactedValue)
+			} else {
+				f.writeString(b, value)
+			}
+			b.WriteByte('\n')
+		}
+	}
+}
@@ -0,0 +1,392 @@
+package httplog
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// forwardedRecord returns a record for a request forwarded by a proxy.
+func forwardedRecord() *Record {
+	r := NewRecord()
+	r.Method, r.URI, r.Proto = "GET", "/", "HTTP/1.1"
+	r.RemoteAddr = "10.0.0.1:1234"
+	r.Request.Header = http.Header{
+		"Forwarded":       {`for="[2001:db8::1]:4711";proto=https, for=10.0.0.2`},
+		"X-Forwarded-For": {"203.0.113.7, 10.0.0.2"},
+	}
+	return r
+}
+
+func TestFormatClientConcurrent(t *testing.T) {
+	r := forwardedRecord()
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if got, want := r.Format("%a %a %{client_port}x"), "2001:db8::1 2001:db8::1 4711"; got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func BenchmarkFormatClientAddr(b *testing.B) {
+	r := forwardedRecord()
+	for _, format := range []string{"%a", "%a %a", "%a %h %{client_port}x"} {
+		b.Run(format, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				r.Format(format)
+			}
+		})
+	}
+}
+
+// standardFormats are the predefined formats, used by benchmarks.
+var standardFormats = []struct{ name, format string }{
+	{"Simple", SimpleLogFormat},
+	{"Basic", BasicLogFormat},
+	{"Common", CommonLogFormat},
+	{"NCSA", NCSALogFormat},
+}
+
+// completedRecord returns a record of a typical completed request.
+func completedRecord() *Record {
+	r := NewRecord()
+	r.Method, r.URI, r.Proto = "GET", "/index.html?q=1", "HTTP/1.1"
+	r.Host, r.RemoteAddr = "example.com", "192.0.2.1:1234"
+	r.Request.Header = http.Header{
+		"Referer":    {"https://example.com/"},
+		"User-Agent": {"Mozilla/5.0 (X11; Linux x86_64)"},
+	}
+	r.Status, r.Size = 200, 1234
+	start := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	r.SetTimes(start, start.Add(1500*time.Microsecond))
+	return r
+}
+
+func TestAppendFormat(t *testing.T) {
+	r := completedRecord()
+	for _, f := range standardFormats {
+		want := r.Format(f.format)
+		if got := string(r.AppendFormat([]byte("> "), f.format)); got != "> "+want {
+			t.Errorf("%s: got %q, want %q", f.name, got, "> "+want)
+		}
+	}
+}
+
+func BenchmarkFormat(b *testing.B) {
+	r := completedRecord()
+	for _, f := range standardFormats {
+		b.Run(f.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				r.Format(f.format)
+			}
+		})
+	}
+}
+
+func BenchmarkAppendFormat(b *testing.B) {
+	r := completedRecord()
+	for _, f := range standardFormats {
+		b.Run(f.name, func(b *testing.B) {
+			b.ReportAllocs()
+			var buf []byte
+			for i := 0; i < b.N; i++ {
+				buf = r.AppendFormat(buf[:0], f.format)
+			}
+		})
+	}
+}
+
+func TestFormatHeaderJoin(t *testing.T) {
+	r := completedRecord()
+	r.Request.Header = http.Header{
+		"Cookie": {"a=1", "b=2"},
+		"Via":    {"1.1 a", "1.1 b"},
+	}
+	r.Response.Header = http.Header{
+		"Set-Cookie": {"a=1; Expires=Wed, 21 Oct 2015 07:28:00 GMT", "b=2; Path=/"},
+	}
+	tests := []struct {
+		join, format, want string
+	}{
+		{"", "%{Cookie}i", "a=1; b=2"},
+		{"", "%{Via}i", "1.1 a,1.1 b"},
+		{" | ", "%{Via}i", "1.1 a | 1.1 b"},
+		{" | ", "%{Cookie}i", "a=1; b=2"},
+		{"", "%{Set-Cookie}o", `a=1; Expires=Wed, 21 Oct 2015 07:28:00 GMT\nb=2; Path=/`},
+		{" | ", "%{set-cookie}o", `a=1; Expires=Wed, 21 Oct 2015 07:28:00 GMT\nb=2; Path=/`},
+	}
+	for _, test := range tests {
+		f := &Formatter{HeaderJoin: test.join}
+		if got := f.Format(r, test.format); got != test.want {
+			t.Errorf("HeaderJoin %q, %s: got %q, want %q", test.join, test.format, got, test.want)
+		}
+	}
+}
+
+// durationRecord returns a record of a request that took d.
+func durationRecord(d time.Duration) *Record {
+	r := NewRecord()
+	start := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	r.SetTimes(start, start.Add(d))
+	return r
+}
+
+func TestFormatDurationUnits(t *testing.T) {
+	tests := []struct {
+		d            time.Duration
+		format, want string
+	}{
+		{90 * time.Second, "%{ns}T", "90000000000"},
+		{90 * time.Second, "%{us}T", "90000000"},
+		{90 * time.Second, "%{ms}T", "90000"},
+		{90 * time.Second, "%{s}T", "90"},
+		{90 * time.Second, "%{min}T", "1.5"},
+		{1500 * time.Microsecond, "%{ns}T", "1500000"},
+		{1500 * time.Microsecond, "%{us}T", "1500"},
+		{1500 * time.Microsecond, "%{ms}T", "1.5"},
+		{1500 * time.Microsecond, "%{s}T", "0.0015"},
+		{1500 * time.Microsecond, "%T", "0.0015"},
+		{1500 * time.Microsecond, "%{h}T", "%{h}T"},
+		{1500 * time.Microsecond, "%{}T", "%{}T"},
+	}
+	for _, test := range tests {
+		if got := durationRecord(test.d).Format(test.format); got != test.want {
+			t.Errorf("%v, %s: got %q, want %q", test.d, test.format, got, test.want)
+		}
+	}
+}
+
+func TestFormatRequestTarget(t *testing.T) {
+	u, err := url.ParseRequestURI("/a%2Fb?x=%2F")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		uri  string
+		url  *url.URL
+		want string
+	}{
+		{"/a%2Fb?x=%2F", u, "/a%2Fb|?x=%2F|GET /a%2Fb?x=%2F HTTP/1.1"},
+		{"http://example.com/a%2Fb?x=%2F", u, "/a%2Fb|?x=%2F|GET http://example.com/a%2Fb?x=%2F HTTP/1.1"},
+		{"/a%2Fb", u, "/a%2Fb||GET /a%2Fb HTTP/1.1"},
+		// Without the URI sent by the client, the URL is used.
+		{"", u, "/a/b|?x=%2F|GET /a%2Fb?x=%2F HTTP/1.1"},
+	}
+	for _, test := range tests {
+		r := NewRecord()
+		r.Method, r.URI, r.URL, r.Proto = "GET", test.uri, test.url, "HTTP/1.1"
+		if got := r.Format("%U|%q|%r"); got != test.want {
+			t.Errorf("URI %q: got %q, want %q", test.uri, got, test.want)
+		}
+	}
+}
+
+func TestFormatCookie(t *testing.T) {
+	r := completedRecord()
+	r.Request.Header = http.Header{"Cookie": {"a=1; b=2; session=xyz", "c=3; session=other"}}
+	tests := []struct {
+		fold         bool
+		format, want string
+	}{
+		{false, "%{session}C", "xyz"},
+		{false, "%{a}C|%{b}C|%{c}C", "1|2|3"},
+		{false, "%{missing}C", ""},
+		{false, "%{Session}C", ""},
+		{true, "%{Session}C", "xyz"},
+	}
+	for _, test := range tests {
+		f := &Formatter{FoldCookieNames: test.fold}
+		if got := f.Format(r, test.format); got != test.want {
+			t.Errorf("fold %t, %s: got %q, want %q", test.fold, test.format, got, test.want)
+		}
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	r := completedRecord()
+	for _, test := range []struct {
+		size int64
+		want string
+	}{
+		{0, "- 0"},
+		{1, "1 1"},
+		{1234, "1234 1234"},
+	} {
+		r.Size = test.size
+		if got := r.Format("%b %B"); got != test.want {
+			t.Errorf("size %d: got %q, want %q", test.size, got, test.want)
+		}
+	}
+}
+
+func TestFormatDurationMicroseconds(t *testing.T) {
+	// %D is an integer number of microseconds, as with Apache.
+	for _, test := range []struct {
+		d    time.Duration
+		want string
+	}{
+		{0, "0"},
+		{999 * time.Nanosecond, "0"},
+		{1234567 * time.Nanosecond, "1234"},
+		{90 * time.Second, "90000000"},
+	} {
+		if got := durationRecord(test.d).Format("%D"); got != test.want {
+			t.Errorf("%v: got %q, want %q", test.d, got, test.want)
+		}
+	}
+}
+
+func TestFormatRedaction(t *testing.T) {
+	const secret = "s3cr3t"
+	r := completedRecord()
+	r.URI = "/login?user=bob&Token=" + secret + "&api%5Fkey=" + secret
+	r.Request.Header = http.Header{
+		"Authorization": {"Bearer " + secret},
+		"Cookie":        {"session=" + secret, "theme=dark"},
+	}
+	r.Response.Header = http.Header{"Set-Cookie": {"session=" + secret}}
+	f := &Formatter{
+		RedactHeaders:   []string{"authorization", "Cookie", "Set-Cookie"},
+		RedactQuery:     []string{"token", "api_key"},
+		FoldCookieNames: true,
+	}
+	format := `"%r" %q %{Authorization}i %{Cookie}i %{Set-Cookie}o %{session}C %{SESSION}C %{theme}C`
+	got := f.Format(r, format)
+	if strings.Contains(got, secret) {
+		t.Errorf("secret logged: %q", got)
+	}
+	want := `"GET /login?user=bob&Token=***&api%5Fkey=*** HTTP/1.1" ?user=bob&Token=***&api%5Fkey=*** *** *** *** *** *** ***`
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestFormatEpochTimes(t *testing.T) {
+	r := NewRecord()
+	start := time.Date(2020, 1, 2, 3, 4, 5, 123456789, time.UTC)
+	r.SetTimes(start, start.Add(2*time.Second+time.Millisecond))
+	for _, test := range []struct {
+		format, want string
+	}{
+		{"%{sec}t", "1577934245"},
+		{"%{begin:msec}t", "1577934245123"},
+		{"%{msec}t", "1577934245123"},
+		{"%{end:usec}t", "1577934247124456"},
+		{"%{end:sec}t", "1577934247"},
+		{"%{begin:msec_frac}t", "123"},
+		{"%{end:usec_frac}t", "124456"},
+	} {
+		if got := r.Format(test.format); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.format, got, test.want)
+		}
+	}
+}
+
+func TestFormatDurationIntUnits(t *testing.T) {
+	// %{UNIT}D truncates to an integer, while %{UNIT}T does not.
+	d := 90*time.Second + 1500*time.Microsecond
+	for _, test := range []struct {
+		unit, wantD, wantT string
+	}{
+		{"ns", "90001500000", "90001500000"},
+		{"us", "90001500", "90001500"},
+		{"ms", "90001", "90001.5"},
+		{"s", "90", "90.0015"},
+		{"min", "1", "1.500025"},
+		{"h", "%{h}D", "%{h}T"},
+	} {
+		format := "%{" + test.unit + "}D %{" + test.unit + "}T"
+		if got, want := durationRecord(d).Format(format), test.wantD+" "+test.wantT; got != want {
+			t.Errorf("%s: got %q, want %q", format, got, want)
+		}
+	}
+}
+
+func TestFormatEscaping(t *testing.T) {
+	tests := []struct {
+		value, want string
+	}{
+		{"plain", "plain"},
+		{"\n", `\n`},
+		{"\r\nX-Injected=1", `\r\nX-Injected=1`},
+		{"\t", `\t`},
+		{`"`, `\"`},
+		{`\`, `\\`},
+		{"\x00", `\x00`},
+		{"\x1b[31m", `\x1b[31m`},
+		{"\x7f", `\x7f`},
+		{"é", "é"},
+	}
+	const format = `%r|%U|%q|%{X-Test}i|%{X-Test}o|%a`
+	line := func(v string) string {
+		return "GET /p<" + v + ">?q<" + v + "> HTTP/1.1|/p<" + v + ">|?q<" + v + ">|i<" + v + ">|o<" + v + ">|a<" + v + ">"
+	}
+	for _, test := range tests {
+		v := "<" + test.value + ">"
+		r := NewRecord()
+		r.Method, r.URI, r.Proto = "GET", "/p"+v+"?q"+v, "HTTP/1.1"
+		r.RemoteAddr = "192.0.2.1:1234"
+		r.Request.Header = http.Header{"X-Test": {"i" + v}, "X-Forwarded-For": {"a" + v}}
+		r.Response.Header = http.Header{"X-Test": {"o" + v}}
+		if got, want := r.Format(format), line(test.want); got != want {
+			t.Errorf("%q: got %q, want %q", test.value, got, want)
+		}
+		f := &Formatter{NoEscape: true}
+		if got, want := f.Format(r, format), line(test.value); got != want {
+			t.Errorf("%q with NoEscape: got %q, want %q", test.value, got, want)
+		}
+	}
+}
+
+func TestFormatTLS(t *testing.T) {
+	const format = "%{cipher}x|%{version}x|%{sni}x|%{cn}x|%{issuer}x|%{serial}x|%{subject}x|%{clientcert_expiry}x|%{clientcert_days_left}x"
+	f := &Formatter{Location: time.UTC}
+	r := completedRecord()
+	if got, want := f.Format(r, format), "-|-|example.com|-|-|-|-|-|-"; got != want {
+		t.Errorf("without TLS: got %q, want %q", got, want)
+	}
+	cert := &x509.Certificate{
+		Subject:      pkix.Name{CommonName: "client", Organization: []string{"Example"}},
+		Issuer:       pkix.Name{CommonName: "ca"},
+		SerialNumber: big.NewInt(0xabc),
+	}
+	r.TLS = &tls.ConnectionState{
+		Version:          tls.VersionTLS13,
+		CipherSuite:      tls.TLS_AES_128_GCM_SHA256,
+		ServerName:       "sni.example.com",
+		PeerCertificates: []*x509.Certificate{cert},
+	}
+	for _, test := range []struct {
+		expiry time.Duration
+		days   string
+	}{
+		{36 * time.Hour, "1"},
+		{24 * time.Hour, "1"},
+		{12 * time.Hour, "0"},
+		{0, "0"},
+		{-12 * time.Hour, "-1"},
+		{-24 * time.Hour, "-1"},
+		{-36 * time.Hour, "-2"},
+	} {
+		cert.NotAfter = r.StartTime.Add(test.expiry)
+		want := "TLS_AES_128_GCM_SHA256|TLSv1.3|sni.example.com|client|CN=ca|ABC|CN=client,O=Example|" +
+			cert.NotAfter.Format(time.RFC3339) + "|" + test.days
+		if got := f.Format(r, format); got != want {
+			t.Errorf("expiry in %v: got %q, want %q", test.expiry, got, want)
+		}
+	}
+}
@@ -2,9 +2,18 @@
 package httplog
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"runtime/debug"
+	"runtime/metrics"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // LogFn is a function responsible for logging an HTTP request/response.
@@ -12,12 +21,134 @@ type LogFn func(*Record)
 
 // LoggingHandler wraps an http.Handler in order to log processed requests
 // using the provided function. If the logging function needs to reference
-// the passed in *Record, it must make a copy before returning.
+// the passed in *Record, it must make a copy before returning, such as with
+// Record.Clone or Record.CloneAndFormat, unless Pool is NoPool. The record is
+// not reset until the LogFn returns.
 type LoggingHandler struct {
 	http.Handler
 	LogFn
+	// AllocDelta enables recording the number of heap bytes allocated while
+	// the request was processed in Record.AllocDelta. The counter is process
+	// wide, so allocations made concurrently by other goroutines are included,
+	// and reading it adds measurable overhead to every request. The runtime
+	// updates the /gc/heap/allocs:bytes counter when a span is refilled in a
+	// per-P allocation cache (mcache), rather than on every allocation, so
+	// small deltas are logged as 0 or as jumps of a whole span. It is
+	// intended for performance investigations, ideally combined with
+	// sampling.
+	AllocDelta bool
+	// CountRequestBody enables wrapping the request body in order to record the
+	// number of bytes read from it by the handler in Record.RequestBodySize.
+	CountRequestBody bool
+	// ConnectionHeaders lists request headers that are only expected to change
+	// per connection rather than per request. They are logged for the first
+	// request on a connection, and omitted for later requests. This requires
+	// ConnContext to be installed on the http.Server.
+	ConnectionHeaders []string
+	// GenerateRequestID enables request IDs when set. If a request does not
+	// have an ID in the RequestIDHeader header, it is called to generate one.
+	// The ID is stored in Record.RequestID, made available to the handler
+	// through RequestID, and set in the RequestIDHeader response header before
+	// the handler is called. NewRequestID may be used as a generator.
+	GenerateRequestID func() string
+	// RequestIDHeader is the request and response header used for request
+	// IDs. If it is empty, DefaultRequestIDHeader is used.
+	RequestIDHeader string
+	// TagSeparator determines how calls to SetTag are combined. If it is
+	// empty, each call replaces the previous tag. Otherwise, tags are joined
+	// with the separator.
+	TagSeparator string
+	// TraceIDs, if set, is called with the request context to get the IDs of
+	// the active trace and span, which are stored in Record.TraceID and
+	// Record.SpanID. The tracing middleware must run before the LoggingHandler
+	// for its span to be visible.
+	TraceIDs func(ctx context.Context) (traceID, spanID string)
+	// ClientClosedStatus, if non-zero, is logged as the status of requests
+	// where the client disconnected before the handler wrote a response,
+	// rather than the default of 200. Nginx uses 499 for this purpose.
+	ClientClosedStatus int
+	// SuperfluousWriteHeader, if it is not nil, is called when the handler
+	// calls WriteHeader after the response header was already written, which
+	// the http package ignores. It is called synchronously from WriteHeader
+	// with the request and the ignored status code. The number of calls is
+	// also recorded in Response.WriteHeaderCount.
+	SuperfluousWriteHeader func(r *http.Request, statusCode int)
+	// StartLogFn, if it is not nil, is called with the record before the
+	// handler is called, so that the start of long-running requests can be
+	// logged. At that point, only the request fields, StartTime, RequestID,
+	// TraceID and SpanID are set; the Response fields, EndTime and Duration
+	// are zero. As with LogFn, the record must be copied if it is used after
+	// StartLogFn returns.
+	StartLogFn LogFn
+	// InFlightLogFn, if it is not nil, is called periodically while the
+	// handler is running, so that the progress of long-running requests,
+	// such as streams, can be logged. It is called from another goroutine,
+	// every InFlightInterval, with a copy of the record made before the
+	// handler was called, in which Status and Size are the current values
+	// and EndTime and Duration are as if the request ended at that time.
+	// Other Response fields are zero. It is not called after the handler
+	// returns.
+	InFlightLogFn LogFn
+	// InFlightInterval is the interval between calls to InFlightLogFn. If
+	// it is not positive, DefaultInFlightInterval is used.
+	InFlightInterval time.Duration
+	// RecoverPanics enables logging requests whose handler panics. The panic
+	// is recovered, the request is logged with a *PanicError as Record.Err,
+	// and the LoggingHandler then panics with the *PanicError, which formats
+	// the stack trace of the handler with %v, so that it is included in the
+	// message logged by the http package. If the handler did not write the
+	// response, Status is 0, since the http package does not send one. A
+	// panic with http.ErrAbortHandler is logged the same way, but passed on
+	// unchanged, so that the http package aborts the response silently. If
+	// RecoverPanics is not set, panics are not recovered, and requests whose
+	// handler panics are not logged.
+	RecoverPanics bool
+	// Pool provides the records. If it is nil, DefaultRecordPool is used. See
+	// RecordPool for the trade-offs of pooling.
+	Pool RecordPool
+	// CloneHeaders causes the request and response header and trailer maps
+	// to be copied into the record, rather than referenced, so that changes
+	// made by the handler or other middleware after the maps are captured do
+	// not affect the record or race with a LogFn that reads it. The request
+	// header is copied before the handler is called, and the others after it
+	// returns. This costs an allocation per map, and is mainly useful if the
+	// record is read while the request is still being processed, such as by
+	// InFlightLogFn.
+	CloneHeaders bool
+	// RouteExtractor, if it is not nil, is called with the request after the
+	// handler returns to get the route that matched it, which is stored in
+	// Record.Route and logged by %{route}x. The route should be a template,
+	// such as "/users/{id}", rather than the request path, so that the number
+	// of distinct values stays small. For example, with http.ServeMux in Go
+	// 1.23 or later:
+	//
+	//	func(r *http.Request) string { return r.Pattern }
+	//
+	// With chi, the LoggingHandler must be installed with the router's Use
+	// method, so that the request has chi's routing context:
+	//
+	//	func(r *http.Request) string {
+	//		if rctx := chi.RouteContext(r.Context()); rctx != nil {
+	//			return rctx.RoutePattern()
+	//		}
+	//		return ""
+	//	}
+	//
+	// With gorilla/mux, mux.CurrentRoute(r) and its GetPathTemplate method
+	// may be used similarly.
+	RouteExtractor func(*http.Request) string
+
+	inFlight atomic.Int64
 }
 
+// DefaultInFlightInterval is the default value of
+// LoggingHandler.InFlightInterval.
+const DefaultInFlightInterval = 30 * time.Second
+
+// DefaultRequestIDHeader is the default value of
+// LoggingHandler.RequestIDHeader.
+const DefaultRequestIDHeader = "X-Request-Id"
+
 // NewLoggingHandler returns an http.Handler that logs completed requests
 // using the given LogFn. If the second parameter is nil, it uses DefaultLogFn.
 func NewLoggingHandler(handler http.Handler, fn LogFn) http.Handler {
@@ -27,24 +158,319 @@ func NewLoggingHandler(handler http.Handler, fn LogFn) http.Handler {
 	return &LoggingHandler{Handler: handler, LogFn: fn}
 }
 
+// NewLoggingHandlerFormat returns an http.Handler that writes a line for each
+// completed request to w, formatted with format, as with NewWriterLogFn. It
+// returns an error if the format is invalid, as reported by ValidateFormat.
+func NewLoggingHandlerFormat(handler http.Handler, w io.Writer, format string) (http.Handler, error) {
+	if err := ValidateFormat(format); err != nil {
+		return nil, err
+	}
+	return NewLoggingHandler(handler, NewWriterLogFn(w, format)), nil
+}
+
+// Middleware returns a function that wraps a handler with NewLoggingHandler
+// and fn, for use with routers and middleware chains that accept
+// func(http.Handler) http.Handler.
+func Middleware(fn LogFn) func(http.Handler) http.Handler {
+	return func(handler http.Handler) http.Handler {
+		return NewLoggingHandler(handler, fn)
+	}
+}
+
+// MiddlewareFormat is like Middleware, but logs to w with format, as with
+// NewLoggingHandlerFormat. It returns an error if the format is invalid.
+func MiddlewareFormat(w io.Writer, format string) (func(http.Handler) http.Handler, error) {
+	if err := ValidateFormat(format); err != nil {
+		return nil, err
+	}
+	return Middleware(NewWriterLogFn(w, format)), nil
+}
+
+// InFlight returns the number of requests that the handler is currently
+// processing.
+func (l *LoggingHandler) InFlight() int64 {
+	return l.inFlight.Load()
+}
+
 func (l *LoggingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	record := recordPool.Get().(*Record)
+	l.inFlight.Add(1)
+	defer l.inFlight.Add(-1)
+	pool := recordPool(l.Pool)
+	record := pool.Get()
 	record.Start()
+	r = r.WithContext(context.WithValue(r.Context(), recordKey{}, record))
 	record.Request.Update(r)
-	rw := WrapResponseWriter(w)
-	l.Handler.ServeHTTP(rw, r)
+	if l.CloneHeaders {
+		record.Request.Header = r.Header.Clone()
+	}
+	if l.GenerateRequestID != nil {
+		header := l.RequestIDHeader
+		if header == "" {
+			header = DefaultRequestIDHeader
+		}
+		if record.RequestID = r.Header.Get(header); record.RequestID == "" {
+			record.RequestID = l.GenerateRequestID()
+		}
+		w.Header().Set(header, record.RequestID)
+	}
+	record.tagSep = l.TagSeparator
+	if l.TraceIDs != nil {
+		record.TraceID, record.SpanID = l.TraceIDs(r.Context())
+	}
+	record.ConnRequests = nextConnRequest(r.Context())
+	if record.ConnRequests > 1 {
+		record.omitHeaders = l.ConnectionHeaders
+	}
+	base := &responseWriter{responseWriter: w}
+	if l.SuperfluousWriteHeader != nil {
+		base.superfluous = func(statusCode int) {
+			l.SuperfluousWriteHeader(r, statusCode)
+		}
+	}
+	rw := base.wrap()
+	var body *countingReader
+	if l.CountRequestBody && r.Body != nil && r.Body != http.NoBody {
+		body = &countingReader{ReadCloser: r.Body}
+		r.Body = body
+	}
+	if l.StartLogFn != nil {
+		l.StartLogFn(record)
+	}
+	var allocs uint64
+	if l.AllocDelta {
+		allocs = heapAllocBytes()
+	}
+	var panicked *PanicError
+	func() {
+		if l.InFlightLogFn != nil {
+			defer l.logInFlight(record, base)()
+		}
+		if l.RecoverPanics {
+			defer func() {
+				if p := recover(); p != nil {
+					panicked = &PanicError{Value: p, Stack: debug.Stack()}
+				}
+			}()
+		}
+		l.Handler.ServeHTTP(rw, r)
+	}()
+	if panicked != nil {
+		record.Err = panicked
+	}
+	if l.AllocDelta {
+		record.AllocDelta = heapAllocBytes() - allocs
+	}
+	if body != nil {
+		record.RequestBodySize = body.n
+	}
+	// Trailers are only set once the handler has read the body, and the
+	// pattern once the handler has routed the request.
+	record.Request.Trailer = r.Trailer
+	record.Pattern = requestPattern(r)
+	if l.RouteExtractor != nil {
+		record.Route = l.RouteExtractor(r)
+	}
 	record.Response.Update(rw)
+	if panicked != nil && !record.Wrote {
+		// The http package does not send a response after a panic.
+		record.Status = 0
+	}
+	if l.CloneHeaders {
+		record.Request.Trailer = r.Trailer.Clone()
+		record.Response.Header = record.Response.Header.Clone()
+	}
+	// The http package cancels the request context if the client disconnects
+	// before the handler returns.
+	record.Aborted = r.Context().Err() == context.Canceled
+	if record.Aborted && !record.Wrote && l.ClientClosedStatus != 0 {
+		record.Status = l.ClientClosedStatus
+	}
 	record.End()
 	if l.LogFn != nil {
 		l.LogFn(record)
 	}
-	record.Reset()
-	recordPool.Put(record)
+	pool.Put(record)
+	if panicked != nil {
+		// Let the http package handle the panic as usual.
+		if panicked.Value == http.ErrAbortHandler {
+			panic(http.ErrAbortHandler)
+		}
+		panic(panicked)
+	}
+}
+
+// PanicError is set as Record.Err when the handler of a LoggingHandler with
+// RecoverPanics panics. After the request is logged, the LoggingHandler
+// panics with the PanicError, so that the http package handles it as usual.
+type PanicError struct {
+	// Value is the value passed to panic.
+	Value interface{}
+	// Stack is the stack trace of the goroutine that panicked, as formatted
+	// by runtime/debug.Stack.
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// Format implements fmt.Formatter. The %v verb formats the error followed by
+// Stack, so that the http package logs where the handler panicked rather than
+// where the LoggingHandler panicked again. Other verbs format the error.
+func (e *PanicError) Format(s fmt.State, verb rune) {
+	io.WriteString(s, e.Error())
+	if verb == 'v' {
+		io.WriteString(s, "\n\n")
+		s.Write(e.Stack)
+	}
 }
 
-// DefaultLogFn logs the record to log.Println using BasicLogFormat.
+// Unwrap returns Value if it is an error, or nil otherwise.
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+// logInFlight starts a goroutine that calls InFlightLogFn periodically with a
+// snapshot of record and the current status and size from rw. The returned
+// function stops the goroutine and waits for it to exit.
+func (l *LoggingHandler) logInFlight(record *Record, rw *responseWriter) func() {
+	interval := l.InFlightInterval
+	if interval <= 0 {
+		interval = DefaultInFlightInterval
+	}
+	snapshot := *record
+	done, exited := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(exited)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				s := snapshot
+				s.Status, s.Size = rw.Status(), rw.Size()
+				s.End()
+				l.InFlightLogFn(&s)
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-exited
+	}
+}
+
+// DefaultFormat is the format used by DefaultLogFn. It may be set, such as to
+// CommonLogFormat, during initialization before any requests are served.
+// Setting it while requests are being logged is a data race, so a LogFn that
+// synchronizes access to its format should be used to change it at runtime.
+var DefaultFormat = BasicLogFormat
+
+// DefaultLogFn logs the record using DefaultFormat to the logger set with
+// SetDefaultLogger, or to the standard logger if none was set.
 func DefaultLogFn(record *Record) {
-	log.Println(record.Format(BasicLogFormat))
+	line := record.Format(DefaultFormat)
+	if l, _ := defaultLogger.Load().(*log.Logger); l != nil {
+		l.Println(line)
+	} else {
+		log.Println(line)
+	}
+}
+
+// defaultLogger holds the *log.Logger used by DefaultLogFn.
+var defaultLogger atomic.Value
+
+// SetDefaultLogger sets the logger that DefaultLogFn writes to, such as one
+// created with log.New(os.Stdout, "", 0), so that access logs can be separated
+// from other logs. If l is nil, the standard logger is used. It is safe to call
+// while requests are being logged.
+func SetDefaultLogger(l *log.Logger) {
+	defaultLogger.Store(l)
 }
 
-var recordPool = sync.Pool{New: func() interface{} { return new(Record) }}
+// NewWriterLogFn returns a LogFn that writes each record to w, formatted with
+// format and followed by a newline. Writes are serialized, so w does not need
+// to be safe for concurrent use. Write errors are ignored.
+func NewWriterLogFn(w io.Writer, format string) LogFn {
+	var mu sync.Mutex
+	return func(record *Record) {
+		line := record.Format(format) + "\n"
+		mu.Lock()
+		io.WriteString(w, line)
+		mu.Unlock()
+	}
+}
+
+// MultiLogFn returns a LogFn that calls each of fns in order with the same
+// record, so that records can be logged to multiple sinks. Nil functions are
+// skipped. As with any LogFn, the functions must not retain the record after
+// they return, since it is reused; a sink that does so should copy it with
+// Record.Clone, or be wrapped with an AsyncLogFn. If a function panics, the
+// remaining functions are still called, and the first panic is then resumed.
+func MultiLogFn(fns ...LogFn) LogFn {
+	return func(record *Record) {
+		var panicked bool
+		var value interface{}
+		for _, fn := range fns {
+			if fn == nil {
+				continue
+			}
+			func() {
+				defer func() {
+					if p := recover(); p != nil && !panicked {
+						panicked, value = true, p
+					}
+				}()
+				fn(record)
+			}()
+		}
+		if panicked {
+			panic(value)
+		}
+	}
+}
+
+// VerboseLogFn returns a LogFn that writes each record to w as a multi-line
+// block for debugging, using DefaultFormatter. See Formatter.VerboseLogFn.
+func VerboseLogFn(w io.Writer) LogFn {
+	return DefaultFormatter.VerboseLogFn(w)
+}
+
+// NewRequestID returns a random 128-bit identifier encoded as 32 hexadecimal
+// digits. It is suitable for use as LoggingHandler.GenerateRequestID.
+func NewRequestID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// countingReader counts the bytes read from a request body.
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+const heapAllocsMetric = "/gc/heap/allocs:bytes"
+
+// heapAllocBytes returns the cumulative number of bytes allocated on the heap
+// by the process. It uses runtime/metrics rather than runtime.ReadMemStats,
+// since the latter stops the world.
+func heapAllocBytes() uint64 {
+	sample := []metrics.Sample{{Name: heapAllocsMetric}}
+	metrics.Read(sample)
+	if sample[0].Value.Kind() != metrics.KindUint64 {
+		return 0
+	}
+	return sample[0].Value.Uint64()
+}
@@ -0,0 +1,339 @@
+package httplog
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLoggingHandlerCloneAndFormat(t *testing.T) {
+	// Records handed to other goroutines with CloneAndFormat remain valid
+	// after the pooled record is reset and reused. Run with -race.
+	const format = `%a "%r" %s %{User-Agent}i`
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var lines []string
+	h := &LoggingHandler{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusTeapot)
+		}),
+		LogFn: func(record *Record) {
+			c, line := record.CloneAndFormat(format)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				again := c.Format(format)
+				mu.Lock()
+				lines = append(lines, line, again)
+				mu.Unlock()
+			}()
+		},
+	}
+	const n = 50
+	for i := 0; i < n; i++ {
+		req := httptest.NewRequest("GET", "/x?y=1", nil)
+		req.Header.Set("User-Agent", "test")
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	wg.Wait()
+	if len(lines) != 2*n {
+		t.Fatalf("got %d lines, want %d", len(lines), 2*n)
+	}
+	for _, line := range lines {
+		if want := `192.0.2.1 "GET /x?y=1 HTTP/1.1" 418 test`; line != want {
+			t.Fatalf("got %q, want %q", line, want)
+		}
+	}
+}
+
+// serveAndFormat serves a GET request for target with handler through a
+// LoggingHandler wrapping w, and returns the record formatted with format.
+func serveAndFormat(w http.ResponseWriter, target string, handler http.HandlerFunc, format string) string {
+	var line string
+	h := &LoggingHandler{
+		Handler: handler,
+		LogFn: func(record *Record) {
+			line = record.Format(format)
+		},
+	}
+	h.ServeHTTP(w, httptest.NewRequest("GET", target, nil))
+	return line
+}
+
+func TestLoggingHandlerWriteHeaderTwice(t *testing.T) {
+	// The logged status is the one sent to the client, which is the first,
+	// since the http package ignores later calls to WriteHeader.
+	tests := []struct {
+		name        string
+		handler     http.HandlerFunc
+		want        string
+		superfluous int
+	}{
+		{"superfluous", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusCreated)
+			w.WriteHeader(http.StatusInternalServerError)
+		}, "201 201 201 2", http.StatusInternalServerError},
+		{"early hints", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusEarlyHints)
+			w.WriteHeader(http.StatusNoContent)
+		}, "204 204 204 1", 0},
+		{"early hints then body", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusEarlyHints)
+			w.Write([]byte("x"))
+		}, "200 200 200 1", 0},
+		{"body then header", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("x"))
+			w.WriteHeader(http.StatusInternalServerError)
+		}, "200 200 200 2", http.StatusInternalServerError},
+	}
+	for _, test := range tests {
+		var line string
+		var superfluous int
+		h := &LoggingHandler{
+			Handler: test.handler,
+			LogFn: func(record *Record) {
+				line = record.Format("%<s %>s %s %{wh-count}x")
+				superfluous = record.SuperfluousStatus
+			},
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		if line != test.want || superfluous != test.superfluous {
+			t.Errorf("%s: got %q, superfluous %d, want %q, %d", test.name, line, superfluous, test.want, test.superfluous)
+		}
+		// The recorder does not handle informational responses.
+		if sent := strings.Fields(line)[0]; w.Code >= 200 && sent != strconv.Itoa(w.Code) {
+			t.Errorf("%s: logged %s, sent %d", test.name, sent, w.Code)
+		}
+	}
+}
+
+func TestLoggingHandlerHijacked(t *testing.T) {
+	// A hijacked connection has no status, which is logged as "-".
+	b := newBaseWriter()
+	got := serveAndFormat(fakeWriters[hasHijacker](b), "/", func(w http.ResponseWriter, r *http.Request) {
+		w.(http.Hijacker).Hijack()
+	}, "%s %<s %>s %b %X")
+	if want := "- - - - -"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if !b.hijacked {
+		t.Error("wrapped writer was not hijacked")
+	}
+}
+
+func TestLoggingHandlerRequestLine(t *testing.T) {
+	got := serveAndFormat(httptest.NewRecorder(), "/path?x=1", func(w http.ResponseWriter, r *http.Request) {}, "%r")
+	if want := "GET /path?x=1 HTTP/1.1"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+// resetPool is a RecordPool that resets records as DefaultRecordPool does and
+// keeps the last one returned.
+type resetPool struct {
+	last *Record
+}
+
+func (p *resetPool) Get() *Record {
+	return NewRecord()
+}
+
+func (p *resetPool) Put(r *Record) {
+	r.Reset()
+	p.last = r
+}
+
+func TestRecordReset(t *testing.T) {
+	// A record returned to the pool must not retain references to the
+	// request.
+	pool := &resetPool{}
+	var logged bool
+	h := &LoggingHandler{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			SetNote(r.Context(), "k", "v")
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte("x"))
+		}),
+		LogFn: func(record *Record) {
+			logged = record.URL != nil && record.Request.Header != nil &&
+				record.Response.Header != nil && record.Context != nil && record.Notes != nil
+		},
+		Pool: pool,
+	}
+	req := httptest.NewRequest("GET", "/x", nil)
+	req.Header.Set("User-Agent", "test")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if !logged {
+		t.Fatal("logged record was not populated")
+	}
+	if pool.last == nil {
+		t.Fatal("record was not returned to the pool")
+	}
+	if !reflect.DeepEqual(*pool.last, Record{}) {
+		t.Errorf("record was not reset: %+v", *pool.last)
+	}
+}
+
+func TestLoggingHandlerPanic(t *testing.T) {
+	tests := []struct {
+		name    string
+		recover bool
+		handler http.HandlerFunc
+		want    string // the logged line, or "" if nothing is logged
+	}{
+		{"before write", true, func(w http.ResponseWriter, r *http.Request) {
+			panic("boom")
+		}, "- panic: boom"},
+		{"after write", true, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusAccepted)
+			panic("boom")
+		}, "202 panic: boom"},
+		{"abort", true, func(w http.ResponseWriter, r *http.Request) {
+			panic(http.ErrAbortHandler)
+		}, "- panic: net/http: abort Handler"},
+		{"not recovered", false, func(w http.ResponseWriter, r *http.Request) {
+			panic("boom")
+		}, ""},
+	}
+	for _, test := range tests {
+		var line string
+		h := &LoggingHandler{
+			Handler: test.handler,
+			LogFn: func(record *Record) {
+				line = record.Format("%s %{error}x")
+			},
+			RecoverPanics: test.recover,
+		}
+		var p interface{}
+		func() {
+			defer func() { p = recover() }()
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+		}()
+		if line != test.want {
+			t.Errorf("%s: logged %q, want %q", test.name, line, test.want)
+		}
+		switch pe, ok := p.(*PanicError); {
+		case test.name == "abort":
+			if p != http.ErrAbortHandler {
+				t.Errorf("%s: panicked with %v, want http.ErrAbortHandler", test.name, p)
+			}
+		case !test.recover:
+			if p != "boom" {
+				t.Errorf("%s: panicked with %v, want boom", test.name, p)
+			}
+		case !ok:
+			t.Errorf("%s: panicked with %T, want *PanicError", test.name, p)
+		case pe.Value != "boom" || !strings.HasPrefix(fmt.Sprint(pe), "panic: boom\n\ngoroutine "):
+			// The stack of the handler is formatted with %v.
+			t.Errorf("%s: panicked with %v", test.name, pe)
+		}
+	}
+}
+
+func TestLoggingHandlerInFlight(t *testing.T) {
+	// InFlightLogFn sees the progress of the response and is not called once
+	// the final LogFn is. Run with -race.
+	var mu sync.Mutex
+	var snapshots []string
+	var finished, late atomic.Bool
+	progress := make(chan string, 100)
+	h := &LoggingHandler{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for s := range progress {
+				if s == "200 0" {
+					break
+				}
+			}
+			w.WriteHeader(http.StatusAccepted)
+			w.Write([]byte("abc"))
+			for s := range progress {
+				if s == "202 3" {
+					return
+				}
+			}
+		}),
+		InFlightLogFn: func(record *Record) {
+			if finished.Load() {
+				late.Store(true)
+			}
+			s := record.Format("%s %B")
+			mu.Lock()
+			snapshots = append(snapshots, s)
+			mu.Unlock()
+			select {
+			case progress <- s:
+			default:
+			}
+		},
+		InFlightInterval: time.Millisecond,
+		LogFn: func(record *Record) {
+			finished.Store(true)
+		},
+	}
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	time.Sleep(10 * time.Millisecond)
+	if late.Load() {
+		t.Error("InFlightLogFn was called after LogFn")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	wrote := false
+	for _, s := range snapshots {
+		switch {
+		case s == "202 3":
+			wrote = true
+		case s != "200 0" || wrote:
+			t.Errorf("snapshots %q, want \"200 0\" until \"202 3\"", snapshots)
+			return
+		}
+	}
+}
+
+func TestLoggingHandlerClientClosed(t *testing.T) {
+	tests := []struct {
+		name         string
+		closedStatus int
+		cancel       bool
+		write        bool
+		want         string
+	}{
+		{"closed", 499, true, false, "499 true X"},
+		{"closed without status", 0, true, false, "200 true X"},
+		{"closed after write", 499, true, true, "201 true X"},
+		{"completed", 499, false, false, "200 false +"},
+	}
+	for _, test := range tests {
+		ctx, cancel := context.WithCancel(context.Background())
+		var line string
+		h := &LoggingHandler{
+			Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if test.write {
+					w.WriteHeader(http.StatusCreated)
+				}
+				if test.cancel {
+					// The http package cancels the context when the client
+					// disconnects.
+					cancel()
+				}
+			}),
+			LogFn: func(record *Record) {
+				line = record.Format("%s %{aborted}x %X")
+			},
+			ClientClosedStatus: test.closedStatus,
+		}
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil).WithContext(ctx))
+		cancel()
+		if line != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, line, test.want)
+		}
+	}
+}
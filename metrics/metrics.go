@@ -0,0 +1,108 @@
+// Package metrics records Prometheus request metrics from httplog records.
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/richshaffer/httplog"
+)
+
+// Options configures the metrics recorded by LogFn.
+type Options struct {
+	// Namespace and Subsystem are used as prefixes of the metric names.
+	Namespace, Subsystem string
+	// Buckets are the request duration histogram buckets, in seconds. If it
+	// is nil, prometheus.DefBuckets is used.
+	Buckets []float64
+	// Route returns the value of the route label for a record. It should
+	// return a value with bounded cardinality, such as a route pattern rather
+	// than the request path. If it is nil, httplog.Record.Route is used,
+	// which is empty unless httplog.LoggingHandler.RouteExtractor is set.
+	Route func(*httplog.Record) string
+}
+
+// LogFn registers request metrics with reg and returns an httplog.LogFn that
+// updates them for each record. Metrics are labeled by request method, status
+// class (e.g. "2xx", or "none" if the connection was hijacked without writing
+// a status) and route. The following metrics are recorded:
+//   http_requests_total - A counter of completed requests.
+//   http_request_duration_seconds - A histogram of request durations.
+//   http_response_size_bytes - A summary of response body sizes.
+func LogFn(reg prometheus.Registerer, opts Options) (httplog.LogFn, error) {
+	labels := []string{"method", "code", "route"}
+	requests := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: opts.Namespace,
+		Subsystem: opts.Subsystem,
+		Name:      "http_requests_total",
+		Help:      "Total number of HTTP requests completed.",
+	}, labels)
+	buckets := opts.Buckets
+	if buckets == nil {
+		buckets = prometheus.DefBuckets
+	}
+	durations := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: opts.Namespace,
+		Subsystem: opts.Subsystem,
+		Name:      "http_request_duration_seconds",
+		Help:      "Duration of HTTP requests in seconds.",
+		Buckets:   buckets,
+	}, labels)
+	sizes := prometheus.NewSummaryVec(prometheus.SummaryOpts{
+		Namespace: opts.Namespace,
+		Subsystem: opts.Subsystem,
+		Name:      "http_response_size_bytes",
+		Help:      "Size of HTTP response bodies in bytes.",
+	}, labels)
+	for _, c := range []prometheus.Collector{requests, durations, sizes} {
+		if err := reg.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return func(record *httplog.Record) {
+		route := record.Route
+		if opts.Route != nil {
+			route = opts.Route(record)
+		}
+		lvs := []string{method(record.Method), statusClass(record.Status), route}
+		requests.WithLabelValues(lvs...).Inc()
+		durations.WithLabelValues(lvs...).Observe(record.Duration.Seconds())
+		sizes.WithLabelValues(lvs...).Observe(float64(record.Size))
+	}, nil
+}
+
+// RegisterInFlight registers a gauge of the number of requests that h is
+// currently processing, named http_requests_in_flight, with reg. See
+// httplog.LoggingHandler.InFlight.
+func RegisterInFlight(reg prometheus.Registerer, h *httplog.LoggingHandler, opts Options) error {
+	return reg.Register(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: opts.Namespace,
+		Subsystem: opts.Subsystem,
+		Name:      "http_requests_in_flight",
+		Help:      "Number of HTTP requests being processed.",
+	}, func() float64 {
+		return float64(h.InFlight())
+	}))
+}
+
+// method returns m if it is a standard method, or "OTHER", to bound the
+// cardinality of the method label.
+func method(m string) string {
+	switch m {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+		http.MethodPatch, http.MethodDelete, http.MethodConnect,
+		http.MethodOptions, http.MethodTrace:
+		return m
+	}
+	return "OTHER"
+}
+
+// statusClass returns the class of an HTTP status code, e.g. "2xx", or "none"
+// if status is 0.
+func statusClass(status int) string {
+	if status == 0 {
+		return "none"
+	}
+	return strconv.Itoa(status/100) + "xx"
+}
@@ -0,0 +1,118 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/richshaffer/httplog"
+)
+
+// recordingRegisterer is a prometheus.Registerer that records the collectors
+// registered with it, so that tests can read their values.
+type recordingRegisterer struct {
+	prometheus.Registerer
+	collectors []prometheus.Collector
+}
+
+func (r *recordingRegisterer) Register(c prometheus.Collector) error {
+	r.collectors = append(r.collectors, c)
+	return r.Registerer.Register(c)
+}
+
+func TestStatusClass(t *testing.T) {
+	for status, want := range map[int]string{
+		0:   "none",
+		101: "1xx",
+		200: "2xx",
+		304: "3xx",
+		404: "4xx",
+		499: "4xx",
+		503: "5xx",
+	} {
+		if got := statusClass(status); got != want {
+			t.Errorf("statusClass(%d) = %q, want %q", status, got, want)
+		}
+	}
+}
+
+func TestMethod(t *testing.T) {
+	for m, want := range map[string]string{
+		"GET":      "GET",
+		"PATCH":    "PATCH",
+		"OPTIONS":  "OPTIONS",
+		"get":      "OTHER",
+		"PROPFIND": "OTHER",
+		"":         "OTHER",
+	} {
+		if got := method(m); got != want {
+			t.Errorf("method(%q) = %q, want %q", m, got, want)
+		}
+	}
+}
+
+func TestLogFn(t *testing.T) {
+	reg := &recordingRegisterer{Registerer: prometheus.NewRegistry()}
+	logFn, err := LogFn(reg, Options{
+		Route: func(r *httplog.Record) string { return "/items/{id}" },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	requests := reg.collectors[0].(*prometheus.CounterVec)
+	for _, r := range []struct {
+		method string
+		status int
+	}{{"GET", 200}, {"GET", 204}, {"BREW", 418}, {"GET", 0}} {
+		record := httplog.NewRecord()
+		record.Method = r.method
+		record.Status = r.status
+		logFn(record)
+	}
+	for _, test := range []struct {
+		method, code string
+		want         float64
+	}{
+		{"GET", "2xx", 2},
+		{"OTHER", "4xx", 1},
+		{"GET", "none", 1},
+		{"BREW", "4xx", 0},
+	} {
+		got := testutil.ToFloat64(requests.WithLabelValues(test.method, test.code, "/items/{id}"))
+		if got != test.want {
+			t.Errorf("http_requests_total{method=%q,code=%q} = %v, want %v", test.method, test.code, got, test.want)
+		}
+	}
+}
+
+func TestRegisterInFlight(t *testing.T) {
+	started, done := make(chan struct{}), make(chan struct{})
+	h := &httplog.LoggingHandler{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			close(started)
+			<-done
+		}),
+		LogFn: func(*httplog.Record) {},
+	}
+	reg := &recordingRegisterer{Registerer: prometheus.NewRegistry()}
+	if err := RegisterInFlight(reg, h, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	gauge := reg.collectors[0]
+	served := make(chan struct{})
+	go func() {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+		close(served)
+	}()
+	<-started
+	if got := testutil.ToFloat64(gauge); got != 1 {
+		t.Errorf("http_requests_in_flight = %v while serving, want 1", got)
+	}
+	close(done)
+	<-served
+	if got := testutil.ToFloat64(gauge); got != 0 {
+		t.Errorf("http_requests_in_flight = %v after serving, want 0", got)
+	}
+}
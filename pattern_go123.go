@@ -0,0 +1,11 @@
+//go:build go1.23
+
+package httplog
+
+import "net/http"
+
+// requestPattern returns the pattern of the http.ServeMux route that matched
+// the request.
+func requestPattern(req *http.Request) string {
+	return req.Pattern
+}
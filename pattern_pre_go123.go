@@ -0,0 +1,11 @@
+//go:build !go1.23
+
+package httplog
+
+import "net/http"
+
+// requestPattern returns an empty string, since http.Request has no Pattern
+// field before Go 1.23.
+func requestPattern(req *http.Request) string {
+	return ""
+}
@@ -0,0 +1,65 @@
+package httplog
+
+import "sync"
+
+// RecordPool provides the Records used by LoggingHandler and
+// LoggingRoundTripper. Implementations must be safe for concurrent use.
+//
+// Pooling avoids allocating a Record for each request, but a pooled record is
+// reused once the LogFn returns, so a LogFn must copy a record it retains, such
+// as with Record.Clone. With NoPool, a LogFn may retain records, and their
+// allocations are attributed to the requests that made them in heap profiles,
+// at the cost of more garbage collection.
+type RecordPool interface {
+	// Get returns a Record with its zero value.
+	Get() *Record
+	// Put returns a Record that is no longer in use to the pool. A pool that
+	// reuses records must reset them with Record.Reset, which releases the
+	// references they hold to request data.
+	Put(*Record)
+}
+
+// DefaultRecordPool is the RecordPool used when none is configured. It is
+// backed by a sync.Pool.
+var DefaultRecordPool RecordPool = &syncRecordPool{
+	pool: sync.Pool{New: func() interface{} { return new(Record) }},
+}
+
+// NoPool is a RecordPool that allocates a new Record for each request and
+// never reuses records.
+var NoPool RecordPool = noPool{}
+
+// NewRecord returns a new Record that does not belong to any pool, such as for
+// constructing records in tests.
+func NewRecord() *Record {
+	return new(Record)
+}
+
+type syncRecordPool struct {
+	pool sync.Pool
+}
+
+func (p *syncRecordPool) Get() *Record {
+	return p.pool.Get().(*Record)
+}
+
+func (p *syncRecordPool) Put(r *Record) {
+	r.Reset()
+	p.pool.Put(r)
+}
+
+type noPool struct{}
+
+func (noPool) Get() *Record {
+	return NewRecord()
+}
+
+func (noPool) Put(*Record) {}
+
+// recordPool returns p, or DefaultRecordPool if p is nil.
+func recordPool(p RecordPool) RecordPool {
+	if p == nil {
+		return DefaultRecordPool
+	}
+	return p
+}
@@ -2,7 +2,6 @@ package httplog
 
 import (
 	"net/http"
-	"strconv"
 	"strings"
 	"time"
 )
@@ -13,13 +12,121 @@ type Record struct {
 	Response
 	StartTime, EndTime time.Time
 	Duration           time.Duration
+	// DurationClamped is true if EndTime was before StartTime, in which case
+	// Duration is 0.
+	DurationClamped bool
+	// AllocDelta is the number of heap bytes allocated while processing the
+	// request. It is only set when LoggingHandler.AllocDelta is enabled, and
+	// is coarse for small allocations; see its documentation.
+	AllocDelta uint64
+	// RequestID is the ID of the request. It is only set when
+	// LoggingHandler.GenerateRequestID is set.
+	RequestID string
+	// TraceID and SpanID identify the active trace span. They are only set
+	// when LoggingHandler.TraceIDs is set.
+	TraceID, SpanID string
+	// Tag is a free-form value set by SetTag.
+	Tag string
+	// Route is the route that matched the request, such as "/users/{id}". It
+	// is only set when LoggingHandler.RouteExtractor is set.
+	Route string
+	// HandlerStart is the time set by MarkHandlerStart, if it was called.
+	HandlerStart time.Time
+	// Notes are the values set by SetNote.
+	Notes map[string]string
+	// Err is an error that occurred while processing the request, such as an
+	// error returned by the RoundTripper of a LoggingRoundTripper, or a
+	// *PanicError if the handler of a LoggingHandler with RecoverPanics
+	// panicked.
+	Err error
+	// omitHeaders lists request headers that should not be logged.
+	omitHeaders []string
+	tagSep      string
 }
 
-// Reset resets the received to its zero value.
+// Reset resets the receiver to its zero value. This releases all references
+// the record holds, such as to the request's URL, context and headers and the
+// response headers, so that a pooled record does not retain request memory.
 func (r *Record) Reset() {
-	r.Request.Reset()
-	r.Response.Reset()
-	r.StartTime, r.EndTime, r.Duration = time.Time{}, time.Time{}, 0
+	*r = Record{}
+}
+
+// Clone returns a copy of the record that remains valid after the original is
+// reset or reused. The request and response header and trailer maps, the
+// request URL and the notes are copied. The TLS connection state and the
+// URL's user information are shared with the original, since the http package
+// does not modify them. The request context and Err are also shared rather
+// than copied, so values the copy reads from them are those of the original.
+func (r *Record) Clone() *Record {
+	c := new(Record)
+	*c = *r
+	c.Request.Header = r.Request.Header.Clone()
+	c.Request.Trailer = r.Request.Trailer.Clone()
+	c.Response.Header = r.Response.Header.Clone()
+	if r.URL != nil {
+		u := *r.URL
+		c.URL = &u
+	}
+	if r.Notes != nil {
+		c.Notes = make(map[string]string, len(r.Notes))
+		for k, v := range r.Notes {
+			c.Notes[k] = v
+		}
+	}
+	return c
+}
+
+// CloneAndFormat returns a copy of the record, as with Clone, together with the
+// copy formatted with format. It is a convenience for a LogFn that passes a
+// record to another goroutine: since the record given to a LogFn is reset
+// and reused once the LogFn returns, the goroutine must only use the copy.
+// Formatting the copy rather than the original also ensures that the
+// original is not modified. See Record.Format.
+func (r *Record) CloneAndFormat(format string) (*Record, string) {
+	c := r.Clone()
+	return c, c.Format(format)
+}
+
+// TimeToFirstByte returns the time from the start of the request until the
+// handler first wrote the response. It returns false if the connection was
+// hijacked or nothing was written.
+func (r *Record) TimeToFirstByte() (time.Duration, bool) {
+	if r.Hijacked || r.FirstByte.IsZero() {
+		return 0, false
+	}
+	return r.FirstByte.Sub(r.StartTime), true
+}
+
+// ConnectionStatus returns the status of the connection after the response was
+// completed: 'X' if the request was aborted, '-' if the connection will be
+// closed or was hijacked, or '+' if it may be kept alive.
+func (r *Record) ConnectionStatus() byte {
+	switch {
+	case r.Aborted:
+		return 'X'
+	case r.Hijacked:
+		return '-'
+	case hasToken(r.Response.Header["Connection"], "close"),
+		hasToken(r.Request.Header["Connection"], "close"):
+		return '-'
+	case r.Proto == "HTTP/1.0" &&
+		!hasToken(r.Request.Header["Connection"], "keep-alive"):
+		return '-'
+	}
+	return '+'
+}
+
+// hasToken reports whether the comma-separated header values contain token,
+// which is compared case-insensitively.
+func hasToken(values []string, token string) bool {
+	for _, v := range values {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
 }
 
 // Start should be called before processing a request to record the start time.
@@ -30,8 +137,38 @@ func (r *Record) Start() {
 // End should be called when done processing a request to update the end time
 // and duration.
 func (r *Record) End() {
-	r.EndTime = time.Now()
-	r.Duration = r.EndTime.Sub(r.StartTime)
+	r.SetTimes(r.StartTime, time.Now())
+}
+
+// SetTimes sets the start and end times of the request and computes the
+// duration. It may be used to construct records manually, such as from parsed
+// logs or in tests. If the times lack monotonic clock readings, as times that
+// are not from time.Now do, the duration is based on wall clock time and may
+// be negative if the clock was changed. A negative duration is clamped to 0,
+// and DurationClamped is set.
+func (r *Record) SetTimes(start, end time.Time) {
+	r.StartTime, r.EndTime = start, end
+	r.Duration = end.Sub(start)
+	r.DurationClamped = r.Duration < 0
+	if r.DurationClamped {
+		r.Duration = 0
+	}
+}
+
+// Elapsed returns the duration of the request. Before End or SetTimes has
+// been called, such as in a StartLogFn, it returns the time elapsed since
+// StartTime, or 0 if StartTime is not set. Afterward, it returns Duration.
+func (r *Record) Elapsed() time.Duration {
+	switch {
+	case !r.EndTime.IsZero():
+		return r.Duration
+	case r.StartTime.IsZero():
+		return 0
+	}
+	if d := time.Since(r.StartTime); d > 0 {
+		return d
+	}
+	return 0
 }
 
 const (
@@ -41,160 +178,244 @@ const (
 	BasicLogFormat = `%a %v %u "%r" %s %T %B "%{User-Agent}i"`
 	// CommonLogFormat is a format string that provides Common Log Format
 	// output.
-	CommonLogFormat = `%a - %u %t "%r" %s %B`
+	CommonLogFormat = `%h %l %u %t "%r" %s %b`
 	// NCSALogFormat can be used to provide NCSA extended log format that
 	// includes referer and user-agent headers.
-	NCSALogFormat = `%a - %u %t "%r" %s %B "%{Referer}i" "%{User-Agent}i"`
+	NCSALogFormat = `%h %l %u %t "%r" %s %b "%{Referer}i" "%{User-Agent}i"`
+	// CombinedLogFormat provides Apache's "combined" log format, which is
+	// the same as NCSALogFormat and is the default of many log analyzers.
+	// Apache's format uses %>s rather than %s, but the two are equivalent.
+	CombinedLogFormat = NCSALogFormat
 )
 
 // Format formats the log record according to a format string. The format
 // directives are loosely based on Apache HTTP Server's mod_log_config:
 //   %% - A literal '%'.
-//   %B - The size in bytes of the response body, not including headers.
-//   %D - The duration of the request, in microseconds (as a floating point
-//        value).
+//   %B - The size in bytes of the response body, not including headers. If
+//        compression middleware is between the LoggingHandler and the
+//        handler, this is the compressed size. See Response.Size.
+//   %D - The duration of the request, in whole microseconds, as with Apache.
+//        Use %{us}T for a floating point value, or %{UNIT}D for other units.
+//   %F - The time to first byte: the time from the start of the request until
+//        the handler first wrote the response, in microseconds. If the
+//        connection was hijacked or nothing was written, "-" is logged.
 //   %H - The request protocol, e.g., "HTTP/1.1".
+//   %I - The number of bytes received, including the request line and headers.
+//        This is an estimate, since the http package does not expose the
+//        exact number of bytes received. The body size is only included when
+//        LoggingHandler.CountRequestBody is enabled.
+//   %L - The request ID, for correlation with other logs, or "-" if there is
+//        none. See LoggingHandler.GenerateRequestID.
+//   %O - The number of bytes sent, including the status line and headers. Like
+//        %I, this is an estimate. See Response.HeaderSize.
+//   %P - The ID of the server process.
+//   %R - The pattern of the http.ServeMux route that handled the request,
+//        e.g. "GET /items/{id}", or "-" if there is none. This requires Go
+//        1.23 or later. See Request.Pattern.
 //   %T - The duration of the request, in seconds (as a floating point value).
-//   %U - The URL path requested, without any query string.
-//   %a - The client IP address. If the request contains a Forwarded or
-//        X-Forwarded-For header, that address (or name) will be used.
-//        Otherwise, the value will be the remote IP address of the connection.
+//   %U - The URL path requested, without any query string. The path is logged
+//        as sent by the client, without decoding, if it is known.
+//   %X - The connection status when the response was completed: "X" if the
+//        request was aborted because the client disconnected, "-" if the
+//        connection will be closed or was hijacked, or "+" if it may be kept
+//        alive.
+//   %a - The client IP address, without any port. If the request contains a
+//        Forwarded or X-Forwarded-For header, that address (or name) will be
+//        used. Otherwise, the value will be the remote IP address of the
+//        connection. See Formatter.Client.
+//   %b - The same as %B, except that "-" is logged rather than 0 if the body is
+//        empty, as in Common Log Format.
+//   %h - The client host name. By default, this is the same as %a, but the
+//        address may be resolved to a host name with reverse DNS lookups. See
+//        Formatter.HostLookup.
+//   %k - The number of keep-alive requests handled on the connection before
+//        this one, i.e. 0 for the first request. This requires ConnContext to
+//        be installed on the http.Server; otherwise it is always 0.
+//   %l - The remote logname from identd, which is not supported, so "-" is
+//        always logged. It is provided for compatibility with Common Log
+//        Format.
 //   %m - The request method, e.g. "GET".
-//   %q - The URL query, if any, including the leading '?'.
-//   %r - The first line of the request, e.g., "GET /path HTTP/1.1".
-//   %s - The numeric response status code.
+//   %p - The server port of the connection. If the port is unknown, such as
+//        when the connection is a unix socket or the *http.Request was not
+//        received from an http.Server, "-" is logged.
+//   %q - The URL query, if any, including the leading '?'. Like %U, it is
+//        logged as sent by the client, if known.
+//   %r - The first line of the request, e.g., "GET /path HTTP/1.1". The
+//        request target is logged as sent by the client, if known.
+//   %s - The numeric response status code sent to the client. If the handler
+//        called WriteHeader more than once, this is the first status, since
+//        the http package ignores later calls. If the connection was hijacked
+//        without writing a status, "-" is logged.
+//   %<s, %>s - The same as %s. Apache distinguishes the original status from
+//              the final status after internal redirects, which the http
+//              package does not have.
 //   %t - The request start time, in format "[02/Jan/2006:15:04:05 -0700]".
-//   %u - The user name from the request, if any.
+//        See Formatter.Location.
+//   %u - The user name from basic authentication credentials or set with
+//        SetUser, if any.
 //   %v - The server name from the Host header or request URL.
-//   %{NAME}C - The value of the cookie with name NAME (case-sensitive).
+//   %{uncompressed}B - The size in bytes of the response body before
+//                      compression, or "-" if it is unknown. See
+//                      ResponseWriter.SetUncompressedSize and
+//                      Response.UncompressedSize.
+//   %{NAME}C - The value of the cookie with name NAME (case-sensitive). If
+//              there is more than one, the first is logged. See
+//              Formatter.FoldCookieNames.
 //   %{UNIT}T - The request duration in the given UNIT. UNIT must be one of
-//              "ms", "us" or "s" for microseconds, milliseconds or seconds.
+//              "ns", "us", "ms", "s" or "min" for nanoseconds, microseconds,
+//              milliseconds, seconds or minutes. Nanoseconds are logged as an
+//              integer, and other units as a floating point value.
+//   %{UNIT}D - The request duration as an integer in the given UNIT, which
+//              may be any of the units accepted by %{UNIT}T, truncated toward
+//              zero. %{us}D is the same as %D.
+//   %{UNIT}F - The time to first byte in the given UNIT, which may be any of
+//              the units accepted by %{UNIT}T.
+//   %{middleware_overhead}T - The time from the start of the request until
+//                             MarkHandlerStart was called, in seconds (as a
+//                             floating point value), or "-" if it wasn't.
+//   %{sse_ttff}T - For text/event-stream responses, the time from the start
+//                  of the request until the response was first flushed, in
+//                  seconds (as a floating point value), or "-" otherwise.
+//   %{c}a - The IP address of the connection peer, ignoring any Forwarded or
+//           X-Forwarded-For headers.
+//   %{NAME}e - The value of the environment variable NAME, such as the name
+//              of the host or pod, or "-" if it is unset or empty. See
+//              Formatter.CacheEnv.
 //   %{NAME}i - The value of the request header with the given name (case-
-//              insensitive).
+//              insensitive). Headers listed in LoggingHandler.ConnectionHeaders
+//              are omitted after the first request on a connection. The
+//              values of a repeated header are joined as described for
+//              Formatter.HeaderJoin.
 //   %{NAME}o - The value of the response header with the given name (case-
 //              insensitive). Note that this won't include headers added by the
 //              http package automatically, such as Date, Content-Length,
 //              Content-Type, Transfer-Encoding and Connection.
+//   %{NAME}^ti - The value of the request trailer with the given name (case-
+//                insensitive), or "-" if it is absent. Trailers are only
+//                available if the handler read the request body completely.
+//   %{NAME}^to - The value of the response trailer with the given name (case-
+//                insensitive), or "-" if it is absent. See Response.Trailer.
+//   %{NAME}n - The value of the note NAME set with SetNote, or "-" if it is
+//              unset.
+//   %{local}p - The same as %p.
+//   %{remote}p - The client port of the connection, or "-" if it is unknown.
+//   %{pid}P - The same as %P.
+//   %{tid}P, %{hextid}P - The ID of the thread that processed the request.
+//                         Since Go does not expose thread or goroutine IDs,
+//                         "-" is always logged. They are accepted for
+//                         compatibility with Apache formats.
 //   %{FORMAT}t - The request time in the provided FORMAT. FORMAT should be a
 //                format string understood by time.Time.Format. If the format
 //                begins with 'end:', the time will be when the request
 //                finished. If the format begins with 'begin:' or has no prefix,
 //                the time will be when the request was started.
+//   %{sec}t - The request start time, in seconds since the Unix epoch.
+//   %{msec}t - The request start time, in milliseconds since the Unix epoch.
+//   %{usec}t - The request start time, in microseconds since the Unix epoch.
+//   %{msec_frac}t - The millisecond fraction of the request start time.
+//   %{usec_frac}t - The microsecond fraction of the request start time.
+//   The epoch forms above may also be prefixed with 'begin:' or 'end:', as
+//   with %{begin:msec}t, to log the start or finish time.
+//   %{NAME}v - The value in the request context for the key registered as
+//              NAME with RegisterContextKey, or "-" if it is unset. The
+//              context is captured before the handler runs, so values added
+//              by middleware inside the LoggingHandler are not visible.
+//   %{NAME}x - Extended values, where NAME is one of the following. Values
+//              that are unavailable are logged as "-".
+//       aborted - "true" if the client disconnected before the handler
+//                 returned, or "false" otherwise.
+//       alloc_delta - The number of heap bytes allocated while processing the
+//                     request. This requires LoggingHandler.AllocDelta, which
+//                     is costly and process wide; see its documentation.
+//       cc:NAME - The argument of the Cache-Control request directive NAME,
+//                 such as "max-age". For directives without an argument, such
+//                 as "no-cache", this is "true" if it is present. If an absent
+//                 directive usually has an argument, "-" is logged; otherwise,
+//                 "false" is logged.
+//       cipher - The TLS cipher suite, e.g. "TLS_AES_128_GCM_SHA256".
+//       client_port - The client port that corresponds to %a, or "-" if the
+//                     client address has no port.
+//       clientcert_days_left - The number of whole days from the start of
+//                              the request until the TLS client certificate
+//                              expires. It is negative if it has expired.
+//       clientcert_expiry - The expiry time of the TLS client certificate, in
+//                           RFC 3339 format.
+//       cn - The common name of the subject of the TLS client certificate, or
+//            "-" if the client did not present one.
+//       error - The message of Record.Err, such as the value passed to panic
+//               by the handler (see LoggingHandler.RecoverPanics), or "-" if
+//               there was no error.
+//       flushed - "true" if the handler flushed the response, indicating that
+//                 it was streamed, or "false" otherwise.
+//       grpc-status - The gRPC status of the response, such as "OK" or
+//                     "NOT_FOUND", from the Grpc-Status trailer, or "-" if
+//                     there is none. See Response.GRPCStatus and
+//                     Formatter.GRPCStatusTrailer.
+//       host - The host requested from the first proxy, from the Forwarded
+//              header, or the Host header if it is not reported. Compare with
+//              %v.
+//       issuer - The distinguished name of the issuer of the TLS client
+//                certificate, or "-" if the client did not present one.
+//       proto - The protocol used to make the request to the first proxy,
+//               e.g. "https", from the Forwarded header.
+//       request-id - The request ID. See LoggingHandler.GenerateRequestID.
+//       route - The route that matched the request, or "-" if it is unknown.
+//               See LoggingHandler.RouteExtractor.
+//       scheme - The scheme of the request, "http" or "https". If
+//                Formatter.Client has TrustedProxies, the scheme reported by
+//                trusted proxies in the headers listed in its Headers is
+//                used. See ClientResolver.Scheme.
+//       serial - The serial number of the TLS client certificate, in uppercase
+//                hexadecimal, or "-" if the client did not present one.
+//       sni - The server name from the TLS handshake, or the Host header if
+//             the request was not received over TLS or no server name was
+//             sent. Compare with %v.
+//       span-id - The ID of the active trace span. See
+//                 LoggingHandler.TraceIDs.
+//       sse_events - For text/event-stream responses, the number of times the
+//                    response was flushed, which approximates the number of
+//                    events sent.
+//       subject - The distinguished name of the TLS client certificate, or "-"
+//                 if the client did not present one.
+//       tag - The value set with SetTag.
+//       trace-id - The ID of the active trace. See LoggingHandler.TraceIDs.
+//       version - The TLS version, e.g. "TLSv1.3".
+//       wh-count - The number of times the handler wrote the final response
+//                  header. If it is more than 1, the handler made superfluous
+//                  calls to WriteHeader. See ResponseWriter.WriteHeaderCount.
+//       written - "true" if the handler wrote the response header, explicitly
+//                 or by writing the body, or "false" otherwise.
+//   %{NAME}X - The value of the custom directive NAME. See RegisterDirective
+//              and Formatter.Directives. Custom directives with single-
+//              character names may also be used as "%N".
 //
-// Invalid format directives will be passed through unchanged.
+// String values from the request and response, such as header values, are
+// escaped to prevent log injection. See Formatter.NoEscape.
+//
+// Invalid format directives will be passed through unchanged. ValidateFormat
+// can be used to detect them in advance.
+//
+// Format uses DefaultFormatter. To use other formatting options, create a
+// Formatter and use its Format method.
 func (r *Record) Format(format string) string {
-	var b strings.Builder
-	for i, l := 0, len(format); i < l; i++ {
-		switch format[i] {
-		case '%':
-			if i++; i == l {
-				b.WriteByte('%')
-				return b.String()
-			}
-			switch format[i] {
-			case '%':
-				b.WriteByte('%')
-			case 'B':
-				b.WriteString(strconv.FormatInt(r.Size, 10))
-			case 'D':
-				ms := float64(r.Duration) / float64(time.Microsecond)
-				b.WriteString(strconv.FormatFloat(ms, 'f', -1, 64))
-			case 'H':
-				b.WriteString(r.Proto)
-			case 'T':
-				s := r.Duration.Seconds()
-				b.WriteString(strconv.FormatFloat(s, 'f', -1, 64))
-			case 'U':
-				b.WriteString(r.URL.Path)
-			case 'a':
-				b.WriteString(r.ClientAddr())
-			case 'm':
-				b.WriteString(r.Method)
-			case 'q':
-				if r.URL.RawQuery != "" {
-					b.WriteByte('?')
-					b.WriteString(r.URL.RawQuery)
-				}
-			case 'r':
-				b.WriteString(r.Method)
-				b.WriteByte(' ')
-				b.WriteString(r.Proto)
-				b.WriteByte(' ')
-				b.WriteString(r.URL.String())
-			case 's':
-				b.WriteString(strconv.FormatInt(int64(r.Status), 10))
-			case 't':
-				b.WriteString(r.StartTime.Format("[02/Jan/2006:15:04:05 -0700]"))
-			case 'u':
-				if r.Request.User != "" {
-					b.WriteString(r.Request.User)
-				} else {
-					b.WriteByte('-')
-				}
-			case 'v':
-				b.WriteString(r.Host)
-			case '{':
-				j := i + 1
-				for ; j < l && format[j] != '}'; j++ {
-				}
-				if j == l {
-					b.WriteByte('%')
-					b.WriteString(format[i:])
-					return b.String()
-				}
-				key := format[i+1 : j]
-				j++
-				if j == l {
-					b.WriteByte('%')
-					b.WriteString(format[i:])
-					return b.String()
-				}
-				i = j
-				switch format[j] {
-				case 'C':
-					cookies, _ := ParsePairs(r.Request.Header.Get("Cookie"), false)
-					b.WriteString(cookies[key])
-				case 'T':
-					switch key {
-					case "ms":
-						ms := float64(r.Duration) / float64(time.Millisecond)
-						b.WriteString(strconv.FormatFloat(ms, 'f', -1, 64))
-					case "us":
-						ms := float64(r.Duration) / float64(time.Microsecond)
-						b.WriteString(strconv.FormatFloat(ms, 'f', -1, 64))
-					case "s":
-						s := r.Duration.Seconds()
-						b.WriteString(strconv.FormatFloat(s, 'f', -1, 64))
-					default:
-						b.WriteString("%{")
-						b.WriteString(key)
-						b.WriteString("}T")
-					}
-				case 'i':
-					headers := r.Request.Header[http.CanonicalHeaderKey(key)]
-					b.WriteString(strings.Join(headers, ","))
-				case 'o':
-					headers := r.Response.Header[http.CanonicalHeaderKey(key)]
-					b.WriteString(strings.Join(headers, ","))
-				case 't':
-					if strings.HasPrefix(key, "end:") {
-						b.WriteString(r.EndTime.Format(strings.TrimPrefix(key, "end:")))
-					} else {
-						b.WriteString(r.StartTime.Format(strings.TrimPrefix(key, "begin:")))
-					}
-				default:
-					b.WriteString("%{")
-					b.WriteString(key)
-					b.WriteByte('}')
-					b.WriteByte(format[j])
-				}
-			default:
-				b.WriteByte('%')
-				b.WriteByte(format[i])
-			}
-		default:
-			b.WriteByte(format[i])
+	return DefaultFormatter.Format(r, format)
+}
+
+// AppendFormat is like Format, but appends the formatted record to dst and
+// returns the extended buffer. It can be used with a reused buffer to avoid
+// allocating a string for each record, such as when writing records directly
+// to an io.Writer.
+func (r *Record) AppendFormat(dst []byte, format string) []byte {
+	return DefaultFormatter.AppendFormat(dst, r, format)
+}
+
+// omitHeader reports whether the request header with the canonical name should
+// be omitted from the log.
+func (r *Record) omitHeader(name string) bool {
+	for _, h := range r.omitHeaders {
+		if http.CanonicalHeaderKey(h) == name {
+			return true
 		}
 	}
-	return b.String()
+	return false
 }
@@ -1,11 +1,16 @@
 package httplog
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"net/url"
 	"strings"
+	"time"
 )
 
 // Request contains information from a client request.
@@ -18,7 +23,32 @@ type Request struct {
 	ContentLength int64
 	Host          string
 	RemoteAddr    string
+	LocalAddr     string
 	User          string
+	TLS           *tls.ConnectionState
+	// Context is the context of the request passed to the LoggingHandler.
+	// Contexts derived from it by the handler or inner middleware are not
+	// recorded.
+	Context context.Context
+	// Trailer contains the request trailers. As with http.Request, values are
+	// only available after the request body has been read completely.
+	Trailer http.Header
+	// Scheme is the scheme of the request as received, "http" or "https",
+	// which is based on whether it was received over TLS. For outbound
+	// requests, it is the scheme of the URL. See ClientResolver.Scheme for
+	// the scheme used by clients of proxies.
+	Scheme string
+	// Pattern is the pattern of the http.ServeMux route that matched the
+	// request, e.g. "GET /items/{id}", or an empty string if no pattern
+	// matched. It requires Go 1.23 or later.
+	Pattern string
+	// ConnRequests is the number of requests received on the connection,
+	// including this one. It is only set by LoggingHandler when ConnContext is
+	// installed on the http.Server.
+	ConnRequests int64
+	// RequestBodySize is the number of bytes of the request body read by the
+	// handler. It is only set when LoggingHandler.CountRequestBody is enabled.
+	RequestBodySize int64
 }
 
 // NewRequest returns a new Request from an *http.Request variable.
@@ -28,7 +58,8 @@ func NewRequest(req *http.Request) *Request {
 	return r
 }
 
-// Reset sets the receiver to its zero value.
+// Reset sets the receiver to its zero value. This releases references to the
+// request's URL, headers and context.
 func (r *Request) Reset() {
 	*r = Request{}
 }
@@ -40,26 +71,221 @@ func (r *Request) Update(req *http.Request) {
 	r.URL = req.URL
 	r.Proto = req.Proto
 	r.Header = req.Header
+	r.Trailer = req.Trailer
+	r.Pattern = requestPattern(req)
 	r.ContentLength = req.ContentLength
 	r.Host = req.Host
 	r.RemoteAddr = req.RemoteAddr
+	if addr, ok := req.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		r.LocalAddr = addr.String()
+	}
 	r.User, _, _ = req.BasicAuth()
+	r.TLS = req.TLS
+	switch {
+	case req.RequestURI == "" && req.URL != nil && req.URL.Scheme != "":
+		// An outbound request.
+		r.Scheme = req.URL.Scheme
+	case req.TLS != nil:
+		r.Scheme = "https"
+	default:
+		r.Scheme = "http"
+	}
+	r.Context = req.Context()
+}
+
+// PeerAddr returns the IP address of the remote end of the connection, without
+// a port and regardless of any forwarding headers. If RemoteAddr has no port,
+// as with unix socket connections, it is returned with any brackets removed.
+func (r *Request) PeerAddr() string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return strings.TrimSuffix(strings.TrimPrefix(r.RemoteAddr, "["), "]")
+	}
+	return host
+}
+
+// TLSVersion returns the name of the negotiated TLS version, e.g. "TLSv1.3",
+// or an empty string if the request was not received over TLS.
+func (r *Request) TLSVersion() string {
+	if r.TLS == nil {
+		return ""
+	}
+	switch r.TLS.Version {
+	case tls.VersionTLS10:
+		return "TLSv1"
+	case tls.VersionTLS11:
+		return "TLSv1.1"
+	case tls.VersionTLS12:
+		return "TLSv1.2"
+	case tls.VersionTLS13:
+		return "TLSv1.3"
+	}
+	return fmt.Sprintf("0x%04X", r.TLS.Version)
+}
+
+// TLSCipher returns the name of the negotiated cipher suite, or an empty string
+// if the request was not received over TLS.
+func (r *Request) TLSCipher() string {
+	if r.TLS == nil {
+		return ""
+	}
+	return tls.CipherSuiteName(r.TLS.CipherSuite)
+}
+
+// TLSSubject returns the subject of the client certificate, or an empty string
+// if the client did not present a certificate.
+func (r *Request) TLSSubject() string {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return ""
+	}
+	return r.TLS.PeerCertificates[0].Subject.String()
+}
+
+// ServerName returns the server name requested by the client in the TLS
+// handshake (SNI). If the request was not received over TLS, or the client did
+// not send a server name, Host is returned.
+func (r *Request) ServerName() string {
+	if r.TLS != nil && r.TLS.ServerName != "" {
+		return r.TLS.ServerName
+	}
+	return r.Host
+}
+
+// ClientCertExpiry returns the expiry time of the TLS client certificate, or the
+// zero time if the client did not present a certificate.
+func (r *Request) ClientCertExpiry() time.Time {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return time.Time{}
+	}
+	return r.TLS.PeerCertificates[0].NotAfter
+}
+
+// ClientCertificate returns the TLS client certificate, or nil if the client
+// did not present a certificate.
+func (r *Request) ClientCertificate() *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return nil
+	}
+	return r.TLS.PeerCertificates[0]
+}
+
+// RemotePort returns the port of the remote end of the connection, or an empty
+// string if RemoteAddr has no port.
+func (r *Request) RemotePort() string {
+	return addrPort(r.RemoteAddr)
+}
+
+// LocalPort returns the port of the server end of the connection, or an empty
+// string if the local address is unknown or has no port.
+func (r *Request) LocalPort() string {
+	return addrPort(r.LocalAddr)
+}
+
+// addrPort returns the port from a "host:port" address, or an empty string if
+// the address has no port (e.g., a unix socket path).
+func addrPort(addr string) string {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return ""
+	}
+	return port
+}
+
+// RequestTarget returns the request target from the request line, e.g.
+// "/path?query". It is the unmodified URI sent by the client, if known.
+// Otherwise, it is derived from URL.
+func (r *Request) RequestTarget() string {
+	if r.URI != "" || r.URL == nil {
+		return r.URI
+	}
+	return r.URL.RequestURI()
+}
+
+// RequestPath returns the path of the request target, without any query. If
+// the URI sent by the client is known, the path is returned as it was sent,
+// without decoding. Otherwise, URL.Path is returned.
+func (r *Request) RequestPath() string {
+	if r.URI == "" {
+		if r.URL == nil {
+			return ""
+		}
+		return r.URL.Path
+	}
+	path, _ := r.splitURI()
+	return path
+}
+
+// RequestQuery returns the query of the request target, without the leading
+// '?'. If the URI sent by the client is known, the query is returned as it was
+// sent. Otherwise, URL.RawQuery is returned.
+func (r *Request) RequestQuery() string {
+	if r.URI == "" {
+		if r.URL == nil {
+			return ""
+		}
+		return r.URL.RawQuery
+	}
+	_, query := r.splitURI()
+	return query
+}
+
+// splitURI splits URI into a path and query. If URI is in absolute form, e.g.
+// "http://host/path", the scheme and authority are removed.
+func (r *Request) splitURI() (path, query string) {
+	path = r.URI
+	if i := strings.Index(path, "://"); i >= 0 && !strings.HasPrefix(path, "/") {
+		path = path[i+3:]
+		if j := strings.IndexByte(path, '/'); j >= 0 {
+			path = path[j:]
+		} else {
+			path = "/"
+		}
+	}
+	if i := strings.IndexByte(path, '?'); i >= 0 {
+		return path[:i], path[i+1:]
+	}
+	return path, ""
+}
+
+// HeaderSize returns an estimate of the number of bytes in the request line
+// and headers as received, as if the request was sent using HTTP/1.1. The
+// http package does not expose the exact number of bytes received.
+func (r *Request) HeaderSize() int64 {
+	n := len(r.Method) + len(r.RequestTarget()) + len(r.Proto) + 4 // 2 spaces and CRLF
+	if r.Host != "" {
+		n += len("Host: ") + len(r.Host) + 2
+	}
+	return int64(n) + headerSize(r.Header) + 2
+}
+
+// headerSize returns the size of the header fields in h serialized in HTTP/1.1
+// wire format.
+func headerSize(h http.Header) int64 {
+	var n int
+	for k, vs := range h {
+		for _, v := range vs {
+			n += len(k) + len(v) + 4 // ": " and CRLF
+		}
+	}
+	return int64(n)
 }
 
 // FirstForwardedFor attempts to parse an IP address from Forwarded and
 // X-Forwarded-For headers. If no client IP address is found in those headers,
 // it returns an empty string.
 func (r *Request) FirstForwardedFor() string {
-	forwardHeaders := r.Header["Forwarded"]
-	for i := range forwardHeaders {
-		pairs, _ := ParsePairs(forwardHeaders[i], true)
-		if f := pairs["for"]; f != "" {
-			return f
-		}
+	if _, _, _, f := r.Forwarded(); f != "" {
+		return f
 	}
-	forwardHeaders = r.Header["X-Forwarded-For"]
-	for i := range forwardHeaders {
-		hops := strings.SplitN(forwardHeaders[i], ",", 2)
+	return firstValue(r.Header["X-Forwarded-For"])
+}
+
+// firstValue returns the first comma-separated value of the header values,
+// such as the first address in X-Forwarded-For headers, or an empty string if
+// there is none.
+func firstValue(values []string) string {
+	for _, v := range values {
+		hops := strings.SplitN(v, ",", 2)
 		if len(hops) != 0 {
 			return strings.TrimSpace(hops[0])
 		}
@@ -67,32 +293,227 @@ func (r *Request) FirstForwardedFor() string {
 	return ""
 }
 
+// headerValues returns the comma-separated values of the header values, such
+// as the addresses in X-Forwarded-For headers, in order and without
+// surrounding whitespace.
+func headerValues(values []string) []string {
+	var hops []string
+	for _, v := range values {
+		for _, hop := range strings.Split(v, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+	return hops
+}
+
+// Forwarded returns the parameters of the first element of the Forwarded
+// headers, as defined by RFC 7239, which describes the request as received by
+// the first proxy. The element may contain quoted values, such as
+// for="[2001:db8::1]:4711", which are unquoted. Parameters that are absent or
+// that cannot be parsed are returned as empty strings. Since the first element
+// may be sent by the client, it should not be trusted.
+func (r *Request) Forwarded() (proto, host, by, forClient string) {
+	for _, pairs := range r.forwardedElements() {
+		return pairs["proto"], pairs["host"], pairs["by"], pairs["for"]
+	}
+	return "", "", "", ""
+}
+
+// forwardedElements returns the parameters of each element of the Forwarded
+// headers, in order, with lowercase names. Elements that cannot be parsed are
+// returned as nil maps.
+func (r *Request) forwardedElements() []map[string]string {
+	var elems []map[string]string
+	for _, v := range r.Header["Forwarded"] {
+		for _, elem := range splitElements(v) {
+			if elem = strings.TrimSpace(elem); elem == "" {
+				continue
+			}
+			pairs, _ := ParsePairs(elem, true)
+			elems = append(elems, pairs)
+		}
+	}
+	return elems
+}
+
+// SchemeTrusted returns the scheme used by the client for the request, in
+// lowercase. If the connection peer is in one of the trusted networks, such as
+// a TLS-terminating load balancer, the last value of the X-Forwarded-Proto
+// header is used, if present. Otherwise, or if trusted is empty, Scheme is
+// returned. Headers from other peers are ignored, since clients may forge
+// them. It is the same as ClientResolver.Scheme with TrustedProxies set to
+// trusted and the default Headers; use a ClientResolver to consult the
+// Forwarded header instead.
+func (r *Request) SchemeTrusted(trusted []*net.IPNet) string {
+	return (&ClientResolver{TrustedProxies: trusted}).Scheme(r)
+}
+
+// ForwardedHost returns the host requested from the first proxy, as reported
+// by the Forwarded headers, or Host if it is not reported.
+func (r *Request) ForwardedHost() string {
+	if _, host, _, _ := r.Forwarded(); host != "" {
+		return host
+	}
+	return r.Host
+}
+
+// splitElements splits a comma-separated header value into its elements,
+// ignoring commas within quoted strings.
+func splitElements(v string) []string {
+	var elems []string
+	quoted, start := false, 0
+	for i := 0; i < len(v); i++ {
+		switch v[i] {
+		case '"':
+			quoted = !quoted
+		case '\\':
+			if quoted {
+				i++
+			}
+		case ',':
+			if !quoted {
+				elems = append(elems, v[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(elems, v[start:])
+}
+
 // ClientAddr returns the IP address (or possibly host name) of the client for
-// the request. If an identifier is found in Forwarded or X-Forwarded-For
-// headers, it is returned. Otherwise, the remote IP address of the connection
-// the request was received on is returned.
+// the request, without any port. If an identifier is found in Forwarded or
+// X-Forwarded-For headers, it is returned. Otherwise, the remote IP address of
+// the connection the request was received on is returned. It is the same as
+// ClientResolver.ClientAddr with default options.
 func (r *Request) ClientAddr() string {
-	if f := r.FirstForwardedFor(); f != "" {
-		return f
+	return (*ClientResolver)(nil).ClientAddr(r)
+}
+
+// ClientPort returns the port of the client for the request. If a client
+// identifier is found in Forwarded or X-Forwarded-For headers, its port is
+// returned, if it has one. Otherwise, the remote port of the connection is
+// returned. If no port is known, an empty string is returned.
+func (r *Request) ClientPort() string {
+	return (*ClientResolver)(nil).ClientPort(r)
+}
+
+// Cookie returns the value of the named request cookie, parsed from the Cookie
+// headers according to RFC 6265 in the same manner as http.Request.Cookie. If
+// there is more than one cookie with the name, the first is returned. If there
+// is none, an empty string is returned.
+func (r *Request) Cookie(name string) string {
+	req := http.Request{Header: r.Header}
+	c, err := req.Cookie(name)
+	if err != nil {
+		return ""
 	}
-	return r.RemoteAddr
+	return c.Value
+}
+
+// CookieFold is like Cookie, but matches the cookie name case-insensitively.
+// It returns the value of the first cookie whose name matches.
+func (r *Request) CookieFold(name string) string {
+	req := http.Request{Header: r.Header}
+	for _, c := range req.Cookies() {
+		if strings.EqualFold(c.Name, name) {
+			return c.Value
+		}
+	}
+	return ""
+}
+
+// CacheControl returns the directives from the request's Cache-Control headers,
+// as parsed by ParseCacheControl.
+func (r *Request) CacheControl() map[string]string {
+	return ParseCacheControl(strings.Join(r.Header["Cache-Control"], ","))
+}
+
+// ParseCacheControl parses the comma-separated directives of a Cache-Control
+// header value. Directive names are lowercased. Directives without an argument,
+// such as "no-cache", are mapped to an empty string, and quoted arguments are
+// unquoted, with backslash escapes such as \" unescaped, as by ParsePairs.
+// Malformed directives are skipped.
+func ParseCacheControl(text string) map[string]string {
+	var directives map[string]string
+	for s, e := 0, len(text); s < e; s++ {
+		var ns, ne, vs, ve int // name start, name end, val start, val end
+		var escaped bool
+		for ns = s; ns < e && isOWS(text[ns]); ns++ {
+		}
+		for ne = ns; ne < e && text[ne] != '=' && text[ne] != ','; ne++ {
+		}
+		vs, ve, s = ne, ne, ne
+		if ne < e && text[ne] == '=' {
+			for vs = ne + 1; vs < e && isOWS(text[vs]); vs++ {
+			}
+			if vs < e && text[vs] == '"' {
+				vs++
+				ve, escaped = scanQuoted(text, vs)
+				s = ve
+			} else {
+				for ve = vs; ve < e && text[ve] != ','; ve++ {
+				}
+				for s = ve; ve > vs && isOWS(text[ve-1]); ve-- {
+				}
+			}
+			for ; s < e && text[s] != ','; s++ {
+			}
+		}
+		name := strings.ToLower(strings.TrimSpace(text[ns:ne]))
+		if name == "" {
+			continue
+		}
+		if directives == nil {
+			directives = make(map[string]string, 1)
+		}
+		value := text[vs:ve]
+		if escaped {
+			value = unescapeQuoted(value)
+		}
+		directives[name] = value
+	}
+	return directives
 }
 
 // ParsePairs parses 'token=quoted-string' pairs from HTTP headers. The first
 // parameter is the header value without the header name. The second parameter
 // controls case-insensitivity. If it is true, all keys in the returned map
-// will be lowercased.
+// will be lowercased. Optional whitespace around '=' and ';' is ignored.
+// Backslash escapes in quoted-strings, such as \", are unescaped. If a key
+// occurs more than once, the last value is returned. ParsePairsMulti returns
+// all of them.
 func ParsePairs(text string, ci bool) (map[string]string, error) {
+	multi, err := ParsePairsMulti(text, ci)
+	if err != nil {
+		return nil, err
+	}
+	var pairs map[string]string
+	for k, v := range multi {
+		if pairs == nil {
+			pairs = make(map[string]string, len(multi))
+		}
+		pairs[k] = v[len(v)-1]
+	}
+	return pairs, nil
+}
+
+// ParsePairsMulti is like ParsePairs, but returns all of the values of keys
+// that occur more than once. The values for each key are in the order in which
+// they occur in text.
+func ParsePairsMulti(text string, ci bool) (map[string][]string, error) {
 	// This is more complex than splitting on ';' and '=', because
 	// quoted-strings may contain both of those characters.
-	var pairs map[string]string
+	var pairs map[string][]string
 	s, e := 0, len(text)
 	for s < e {
 		var ns, ne, vs, ve int // name start, name end, val start, val end
-		ns = s
-		for ne = s; ne < e && text[ne] != '=' && text[ne] != ';'; ne++ {
+		var escaped bool
+		for ns = s; ns < e && isOWS(text[ns]); ns++ {
+		}
+		for ne = ns; ne < e && text[ne] != '=' && text[ne] != ';'; ne++ {
 		}
-		if ne == ns {
+		name := strings.TrimRight(text[ns:ne], " \t")
+		if name == "" {
 			return nil, fmt.Errorf("empty attribute name at %d", ns)
 		}
 		if ne == e {
@@ -101,36 +522,84 @@ func ParsePairs(text string, ci bool) (map[string]string, error) {
 		if text[ne] == ';' {
 			return nil, fmt.Errorf("found unexpected ';' at %d", ne)
 		}
-		if vs = ne + 1; vs < e && text[vs] == '"' {
-			// value is a quoted-string; find end quote
-			for vs, ve = vs+1, vs+2; ve < e && text[ve] != '"'; ve++ {
+		for vs = ne + 1; vs < e && isOWS(text[vs]); vs++ {
+		}
+		if vs < e && text[vs] == '"' {
+			// value is a quoted-string; find the end quote
+			vs++
+			ve, escaped = scanQuoted(text, vs)
+			if ve == e {
+				return nil, fmt.Errorf("unterminated quoted string at %d", vs-1)
+			}
+			// after closing quote should be end of string or ';'
+			for s = ve + 1; s < e && isOWS(text[s]); s++ {
 			}
-			// after closing quote should be end of string or comma
-			if s = ve + 1; s < len(text) && text[s] != ';' {
+			if s < e && text[s] != ';' {
 				return nil, fmt.Errorf("trailing data after quoted string at %d", s)
 			}
-			s++ // advance to one character after comma (ok if end-of-string)
 		} else {
-			// not a quoted string; find next comma or end of string.
-			for ve = vs; ve < e && text[ve] != ';'; ve++ {
+			// not a quoted string; find next ';' or end of string.
+			for s = vs; s < e && text[s] != ';'; s++ {
+			}
+			for ve = s; ve > vs && isOWS(text[ve-1]); ve-- {
+			}
+			if vs == ve {
+				return nil, fmt.Errorf("empty attribute value at %d", vs)
 			}
-			s = ve + 1 // advance s to one character after comma
-		}
-		if vs == ve {
-			return nil, fmt.Errorf("empty attribute value at %d", vs)
 		}
-		// at end of string, s == e if last character is a comma, else e + 1
-		if s == e {
+		// s is at the ';' after the pair or at the end of the string. A ';'
+		// must be followed by another pair.
+		if s < e && strings.TrimLeft(text[s+1:], " \t") == "" {
 			return nil, fmt.Errorf("trailing ';' at %d", s)
 		}
+		s++
+		value := text[vs:ve]
+		if escaped {
+			value = unescapeQuoted(value)
+		}
 		if pairs == nil {
-			pairs = make(map[string]string, 1)
+			pairs = make(map[string][]string, 1)
 		}
 		if ci {
-			pairs[strings.ToLower(text[ns:ne])] = text[vs:ve]
-		} else {
-			pairs[text[ns:ne]] = text[vs:ve]
+			name = strings.ToLower(name)
 		}
+		pairs[name] = append(pairs[name], value)
 	}
 	return pairs, nil
 }
+
+// scanQuoted returns the index of the quote that ends the quoted-string in
+// text whose contents start at i, skipping escaped characters, or len(text)
+// if it is unterminated. It also reports whether the contents contain any
+// backslash escapes, which unescapeQuoted removes.
+func scanQuoted(text string, i int) (end int, escaped bool) {
+	for ; i < len(text) && text[i] != '"'; i++ {
+		if text[i] == '\\' {
+			escaped = true
+			i++
+		}
+	}
+	if i > len(text) {
+		i = len(text)
+	}
+	return i, escaped
+}
+
+// unescapeQuoted removes the backslashes from the quoted-pairs in the contents
+// of a quoted-string.
+func unescapeQuoted(s string) string {
+	var b strings.Builder
+	b.Grow(len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// isOWS reports whether c is optional whitespace, as defined by RFC 7230.
+func isOWS(c byte) bool {
+	return c == ' ' || c == '\t'
+}
@@ -0,0 +1,87 @@
+package httplog
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestParseCacheControl(t *testing.T) {
+	tests := []struct {
+		text string
+		want map[string]string
+	}{
+		{"", nil},
+		{"no-cache", map[string]string{"no-cache": ""}},
+		{"Max-Age=60, no-store", map[string]string{"max-age": "60", "no-store": ""}},
+		{"max-age = 60 ,private", map[string]string{"max-age": "60", "private": ""}},
+		{`private="a, b", max-age=5`, map[string]string{"private": "a, b", "max-age": "5"}},
+		{`private="a\"b", max-age=5`, map[string]string{"private": `a"b`, "max-age": "5"}},
+		{`private="a\\", no-cache`, map[string]string{"private": `a\`, "no-cache": ""}},
+		{`private="a`, map[string]string{"private": "a"}},
+		{", ,no-cache,", map[string]string{"no-cache": ""}},
+	}
+	for _, test := range tests {
+		if got := ParseCacheControl(test.text); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("ParseCacheControl(%q) = %q, want %q", test.text, got, test.want)
+		}
+	}
+}
+
+func TestParsePairs(t *testing.T) {
+	tests := []struct {
+		text    string
+		ci      bool
+		want    map[string]string
+		wantErr bool
+	}{
+		{"for=1.2.3.4;proto=https", false, map[string]string{"for": "1.2.3.4", "proto": "https"}, false},
+		{"for=1.2.3.4; proto=https", false, map[string]string{"for": "1.2.3.4", "proto": "https"}, false},
+		{" for = 1.2.3.4 ;\tproto= https ", false, map[string]string{"for": "1.2.3.4", "proto": "https"}, false},
+		{`For="[2001:db8::1]:4711" ; Proto=https`, true, map[string]string{"for": "[2001:db8::1]:4711", "proto": "https"}, false},
+		{"a=1; b=2; session=xyz", false, map[string]string{"a": "1", "b": "2", "session": "xyz"}, false},
+		{"a=1; a=2", false, map[string]string{"a": "2"}, false},
+		{"a=1;", false, nil, true},
+		{"a=1; ", false, nil, true},
+		{"a= ; b=2", false, nil, true},
+		{" =1", false, nil, true},
+		{"a", false, nil, true},
+	}
+	for _, test := range tests {
+		got, err := ParsePairs(test.text, test.ci)
+		if (err != nil) != test.wantErr || !reflect.DeepEqual(got, test.want) {
+			t.Errorf("ParsePairs(%q) = %q, %v, want %q, error %t", test.text, got, err, test.want, test.wantErr)
+		}
+	}
+}
+
+func TestRequestForwarded(t *testing.T) {
+	r := &Request{Header: http.Header{
+		"Forwarded": {`for=192.0.2.1; proto=https ; host="example.com", for=10.0.0.1`},
+	}}
+	proto, host, by, forClient := r.Forwarded()
+	if proto != "https" || host != "example.com" || by != "" || forClient != "192.0.2.1" {
+		t.Errorf("got %q, %q, %q, %q", proto, host, by, forClient)
+	}
+}
+
+func TestParsePairsQuoted(t *testing.T) {
+	tests := []struct {
+		text, want string
+		wantErr    bool
+	}{
+		{`token="a\"b"`, `a"b`, false},
+		{`token="a\\b"`, `a\b`, false},
+		{`token="a;b=c"`, "a;b=c", false},
+		{`token="a\b"`, "ab", false},
+		{`token=""`, "", false},
+		{`token="a\"`, "", true},
+		{`token="a" b`, "", true},
+	}
+	for _, test := range tests {
+		got, err := ParsePairs(test.text, false)
+		if (err != nil) != test.wantErr || got["token"] != test.want {
+			t.Errorf("ParsePairs(%q) = %q, %v, want %q, error %t", test.text, got["token"], err, test.want, test.wantErr)
+		}
+	}
+}
@@ -1,13 +1,47 @@
 package httplog
 
-import "net/http"
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
 
 // Response records information from the HTTP server response.
 type Response struct {
-	Status   int
+	// Status is the status code sent to the client. It is 0 if the
+	// connection was hijacked without writing a status.
+	Status int
+	// SuperfluousStatus is the status code of the last call to WriteHeader
+	// that was ignored because the header was already written, or 0. See
+	// ResponseWriter.SuperfluousStatus.
+	SuperfluousStatus int
+	// WriteHeaderCount is the number of times the final response header was
+	// written. See ResponseWriter.WriteHeaderCount.
+	WriteHeaderCount int
+	// Size is the number of bytes of the response body written to the
+	// wrapped http.ResponseWriter. If compression middleware is between the
+	// LoggingHandler and the handler, it is the compressed size.
 	Size     int64
 	Hijacked bool
-	Header   http.Header
+	// Aborted is true if the request context was canceled before the handler
+	// returned, which happens when the client disconnects. It is set by
+	// LoggingHandler.
+	Aborted bool
+	Wrote   bool
+	Flushed bool
+	Header  http.Header
+	// FirstByte is the time the handler first wrote the response.
+	FirstByte time.Time
+	// SSEEvents and SSEFirstFlush are only set for text/event-stream
+	// responses. See ResponseWriter.
+	SSEEvents     int
+	SSEFirstFlush time.Time
+	// UncompressedSize is the size of the response body before compression,
+	// or -1 if it is unknown. See ResponseWriter.UncompressedSize. As with
+	// Size, the zero value means zero bytes, so a Response that is not filled
+	// in from a ResponseWriter should set it to -1 if the size is unknown.
+	UncompressedSize int64
 }
 
 // Reset sets the receiver to its zero value.
@@ -15,10 +49,89 @@ func (r *Response) Reset() {
 	*r = Response{}
 }
 
+// HeaderSize returns an estimate of the number of bytes in the status line and
+// headers sent for the response, as if it was sent using HTTP/1.1. Headers
+// that the http package adds automatically, such as Date and Content-Length,
+// are not visible to the wrapped ResponseWriter and are not counted.
+func (r *Response) HeaderSize() int64 {
+	status := r.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	n := len("HTTP/1.1 000 ") + len(http.StatusText(status)) + 2
+	return int64(n) + headerSize(r.Header) + 2
+}
+
+// Trailer returns the values of the named response trailer, or nil if it was
+// not set. Trailers are found in Header under their names prefixed with
+// http.TrailerPrefix, or under their own names if they were declared in the
+// Trailer header.
+func (r *Response) Trailer(name string) []string {
+	name = http.CanonicalHeaderKey(name)
+	for k, v := range r.Header {
+		if len(k) > len(http.TrailerPrefix) &&
+			strings.HasPrefix(k, http.TrailerPrefix) &&
+			http.CanonicalHeaderKey(k[len(http.TrailerPrefix):]) == name {
+			return v
+		}
+	}
+	if hasToken(r.Header["Trailer"], name) {
+		return r.Header[name]
+	}
+	return nil
+}
+
+// GRPCStatus returns the gRPC status code of the response from the named
+// trailer, usually "Grpc-Status". A trailers-only response, which gRPC sends
+// when there is no response message, carries the status in the header
+// instead, so the header is used if the trailer was not set. A recognized
+// code is returned as its name, such as "NOT_FOUND"; other values are
+// returned unchanged. It returns an empty string if the response has no
+// status, as with plain HTTP responses.
+func (r *Response) GRPCStatus(name string) string {
+	v := firstValue(r.Trailer(name))
+	if v == "" {
+		v = r.Header.Get(name)
+	}
+	if code, err := strconv.Atoi(v); err == nil && code >= 0 && code < len(grpcCodes) {
+		return grpcCodes[code]
+	}
+	return v
+}
+
+// grpcCodes are the names of the gRPC status codes.
+var grpcCodes = [...]string{
+	"OK",
+	"CANCELLED",
+	"UNKNOWN",
+	"INVALID_ARGUMENT",
+	"DEADLINE_EXCEEDED",
+	"NOT_FOUND",
+	"ALREADY_EXISTS",
+	"PERMISSION_DENIED",
+	"RESOURCE_EXHAUSTED",
+	"FAILED_PRECONDITION",
+	"ABORTED",
+	"OUT_OF_RANGE",
+	"UNIMPLEMENTED",
+	"INTERNAL",
+	"UNAVAILABLE",
+	"DATA_LOSS",
+	"UNAUTHENTICATED",
+}
+
 // Update copies values from a ResponseWriter to the receiver.
 func (r *Response) Update(w ResponseWriter) {
 	r.Status = w.Status()
+	r.SuperfluousStatus = w.SuperfluousStatus()
+	r.WriteHeaderCount = w.WriteHeaderCount()
 	r.Size = w.Size()
 	r.Hijacked = w.Hijacked()
+	r.Wrote = w.WroteHeader()
+	r.Flushed = w.Flushed()
 	r.Header = w.Header()
+	r.FirstByte = w.FirstByte()
+	r.SSEEvents = w.SSEEvents()
+	r.SSEFirstFlush = w.SSEFirstFlush()
+	r.UncompressedSize = w.UncompressedSize()
 }
@@ -2,8 +2,12 @@ package httplog
 
 import (
 	"bufio"
+	"io"
 	"net"
 	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
 )
 
 // ResponseWriter augments the http.ResponseWriter type to enable getting the
@@ -11,9 +15,70 @@ import (
 // been hijacked.
 type ResponseWriter interface {
 	http.ResponseWriter
+	// Status returns the status code sent to the client: that of the first
+	// call to WriteHeader with a final (non-informational) status, or 200 if
+	// the body was written first. If neither happened, it returns 200, or 0
+	// if the connection was hijacked.
 	Status() int
+	// SuperfluousStatus returns the status code of the last call to
+	// WriteHeader that the http package ignored because the response header
+	// was already written, or 0 if there was none. See WriteHeaderCount.
+	SuperfluousStatus() int
+	// WriteHeaderCount returns the number of times the final response header
+	// was written, either explicitly with WriteHeader or implicitly by the
+	// first Write. Informational (1xx) headers are not counted. If it is more
+	// than 1, the handler made superfluous calls to WriteHeader, which the
+	// http package ignores.
+	WriteHeaderCount() int
 	Size() int64
 	Hijacked() bool
+	// WroteHeader returns whether the handler wrote the response header,
+	// either explicitly with WriteHeader or implicitly with Write. If it did
+	// not, Status returns 200, which the http package will send by default.
+	WroteHeader() bool
+	// FirstByte returns the time the handler first wrote the response header
+	// or body, or the zero time if it did not.
+	FirstByte() time.Time
+	// Flushed returns whether the response was flushed by the handler.
+	Flushed() bool
+	// SSEEvents returns the number of times a text/event-stream response was
+	// flushed, which approximates the number of events sent.
+	SSEEvents() int
+	// SSEFirstFlush returns the time a text/event-stream response was first
+	// flushed, or the zero time if it never was.
+	SSEFirstFlush() time.Time
+	// SetUncompressedSize sets the size of the response body before it was
+	// compressed. It is intended for compression middleware between the
+	// ResponseWriter and the handler, since Size counts the compressed bytes
+	// written by such middleware.
+	SetUncompressedSize(n int64)
+	// UncompressedSize returns the size set by SetUncompressedSize. If it was
+	// not called, it returns Size if the response has no Content-Encoding
+	// header, or -1 otherwise, since the uncompressed size is unknown.
+	UncompressedSize() int64
+	// Unwrap returns the wrapped http.ResponseWriter. It allows
+	// http.ResponseController to find methods of the wrapped writer that the
+	// ResponseWriter does not implement.
+	Unwrap() http.ResponseWriter
+}
+
+// SetUncompressedSize calls SetUncompressedSize on w if it is a
+// ResponseWriter, or on the first ResponseWriter found by calling the Unwrap
+// methods of w and the writers it wraps. It reports whether one was found.
+// Compression middleware can use it to report the uncompressed size of the
+// response body without depending on the type of w.
+func SetUncompressedSize(w http.ResponseWriter, n int64) bool {
+	for {
+		switch t := w.(type) {
+		case ResponseWriter:
+			t.SetUncompressedSize(n)
+			return true
+		case interface{ Unwrap() http.ResponseWriter }:
+			w = t.Unwrap()
+		default:
+			return false
+		}
+	}
 }
 
 // WrapResponseWriter wraps the http.ResponseWriter in a type that preserves
@@ -23,8 +88,18 @@ type ResponseWriter interface {
 // will return the same result as
 //   val, ok := WrapResponseWriter(rw).(http.InterfaceType)
 // This enables collecting logging statistics without losing the functionality
-// provided by the interfaces.
+// provided by the interfaces. Other methods of the wrapped writer, such as
+// those used by http.ResponseController to set read and write deadlines or to
+// enable full duplex, are found through the Unwrap method, so they are also
+// available through an http.ResponseController for the ResponseWriter.
 func WrapResponseWriter(rw http.ResponseWriter) ResponseWriter {
+	return (&responseWriter{responseWriter: rw}).wrap()
+}
+
+// wrap returns a ResponseWriter for r that implements the same optional
+// interfaces as the http.ResponseWriter that r wraps.
+func (r *responseWriter) wrap() ResponseWriter {
+	rw := r.responseWriter
 	i := 0
 	if _, ok := rw.(http.CloseNotifier); ok {
 		i |= closeNotifier
@@ -38,7 +113,7 @@ func WrapResponseWriter(rw http.ResponseWriter) ResponseWriter {
 	if _, ok := rw.(http.Pusher); ok {
 		i |= pusher
 	}
-	return types[i](rw)
+	return types[i](r)
 }
 
 const (
@@ -48,348 +123,336 @@ const (
 	pusher
 )
 
-var types = [16]func(http.ResponseWriter) ResponseWriter{
-	func(rw http.ResponseWriter) ResponseWriter {
-		return &responseWriter{responseWriter: rw}
+// types maps the set of optional interfaces implemented by a wrapped
+// http.ResponseWriter to a function that returns a ResponseWriter with the
+// same set. Each is an anonymous struct that embeds the *responseWriter along
+// with a shim for each optional interface. Since each shim implements exactly
+// one interface, the method set of the struct is determined by the shims it
+// embeds.
+var types = [16]func(*responseWriter) ResponseWriter{
+	func(rw *responseWriter) ResponseWriter { return rw },
+	func(rw *responseWriter) ResponseWriter {
+		return struct {
+			*responseWriter
+			closeNotifierShim
+		}{rw, closeNotifierShim{rw}}
 	},
-	func(rw http.ResponseWriter) ResponseWriter {
-		return responseWriterCloseNotifier{
-			&responseWriter{responseWriter: rw},
-		}
-	},
-	func(rw http.ResponseWriter) ResponseWriter {
-		return responseWriterFlusher{
-			&responseWriter{responseWriter: rw},
-		}
+	func(rw *responseWriter) ResponseWriter {
+		return struct {
+			*responseWriter
+			flusherShim
+		}{rw, flusherShim{rw}}
 	},
-	func(rw http.ResponseWriter) ResponseWriter {
-		return responseWriterCloseNotifierFlusher{
-			&responseWriter{responseWriter: rw},
-		}
+	func(rw *responseWriter) ResponseWriter {
+		return struct {
+			*responseWriter
+			closeNotifierShim
+			flusherShim
+		}{rw, closeNotifierShim{rw}, flusherShim{rw}}
 	},
-	func(rw http.ResponseWriter) ResponseWriter {
-		return responseWriterHijacker{
-			&responseWriter{responseWriter: rw},
-		}
+	func(rw *responseWriter) ResponseWriter {
+		return struct {
+			*responseWriter
+			hijackerShim
+		}{rw, hijackerShim{rw}}
 	},
-	func(rw http.ResponseWriter) ResponseWriter {
-		return responseWriterCloseNotifierHijacker{
-			&responseWriter{responseWriter: rw},
-		}
+	func(rw *responseWriter) ResponseWriter {
+		return struct {
+			*responseWriter
+			closeNotifierShim
+			hijackerShim
+		}{rw, closeNotifierShim{rw}, hijackerShim{rw}}
 	},
-	func(rw http.ResponseWriter) ResponseWriter {
-		return responseWriterFlusherHijacker{
-			&responseWriter{responseWriter: rw},
-		}
+	func(rw *responseWriter) ResponseWriter {
+		return struct {
+			*responseWriter
+			flusherShim
+			hijackerShim
+		}{rw, flusherShim{rw}, hijackerShim{rw}}
 	},
-	func(rw http.ResponseWriter) ResponseWriter {
-		return responseWriterCloseNotifierFlusherHijacker{
-			&responseWriter{responseWriter: rw},
-		}
+	func(rw *responseWriter) ResponseWriter {
+		return struct {
+			*responseWriter
+			closeNotifierShim
+			flusherShim
+			hijackerShim
+		}{rw, closeNotifierShim{rw}, flusherShim{rw}, hijackerShim{rw}}
 	},
-	func(rw http.ResponseWriter) ResponseWriter {
-		return responseWriterPusher{
-			&responseWriter{responseWriter: rw},
-		}
+	func(rw *responseWriter) ResponseWriter {
+		return struct {
+			*responseWriter
+			pusherShim
+		}{rw, pusherShim{rw}}
 	},
-	func(rw http.ResponseWriter) ResponseWriter {
-		return responseWriterCloseNotifierPusher{
-			&responseWriter{responseWriter: rw},
-		}
+	func(rw *responseWriter) ResponseWriter {
+		return struct {
+			*responseWriter
+			closeNotifierShim
+			pusherShim
+		}{rw, closeNotifierShim{rw}, pusherShim{rw}}
 	},
-	func(rw http.ResponseWriter) ResponseWriter {
-		return responseWriterFlusherPusher{
-			&responseWriter{responseWriter: rw},
-		}
+	func(rw *responseWriter) ResponseWriter {
+		return struct {
+			*responseWriter
+			flusherShim
+			pusherShim
+		}{rw, flusherShim{rw}, pusherShim{rw}}
 	},
-	func(rw http.ResponseWriter) ResponseWriter {
-		return responseWriterCloseNotifierFlusherPusher{
-			&responseWriter{responseWriter: rw},
-		}
+	func(rw *responseWriter) ResponseWriter {
+		return struct {
+			*responseWriter
+			closeNotifierShim
+			flusherShim
+			pusherShim
+		}{rw, closeNotifierShim{rw}, flusherShim{rw}, pusherShim{rw}}
 	},
-	func(rw http.ResponseWriter) ResponseWriter {
-		return responseWriterHijackerPusher{
-			&responseWriter{responseWriter: rw},
-		}
+	func(rw *responseWriter) ResponseWriter {
+		return struct {
+			*responseWriter
+			hijackerShim
+			pusherShim
+		}{rw, hijackerShim{rw}, pusherShim{rw}}
 	},
-	func(rw http.ResponseWriter) ResponseWriter {
-		return responseWriterCloseNotifierHijackerPusher{
-			&responseWriter{responseWriter: rw},
-		}
+	func(rw *responseWriter) ResponseWriter {
+		return struct {
+			*responseWriter
+			closeNotifierShim
+			hijackerShim
+			pusherShim
+		}{rw, closeNotifierShim{rw}, hijackerShim{rw}, pusherShim{rw}}
 	},
-	func(rw http.ResponseWriter) ResponseWriter {
-		return responseWriterFlusherHijackerPusher{
-			&responseWriter{responseWriter: rw},
-		}
+	func(rw *responseWriter) ResponseWriter {
+		return struct {
+			*responseWriter
+			flusherShim
+			hijackerShim
+			pusherShim
+		}{rw, flusherShim{rw}, hijackerShim{rw}, pusherShim{rw}}
 	},
-	func(rw http.ResponseWriter) ResponseWriter {
-		return responseWriterCloseNotifierFlusherHijackerPusher{
-			&responseWriter{responseWriter: rw},
-		}
+	func(rw *responseWriter) ResponseWriter {
+		return struct {
+			*responseWriter
+			closeNotifierShim
+			flusherShim
+			hijackerShim
+			pusherShim
+		}{rw, closeNotifierShim{rw}, flusherShim{rw}, hijackerShim{rw}, pusherShim{rw}}
 	},
 }
 
-//
 type responseWriter struct {
-	responseWriter http.ResponseWriter
-	status         int
+	// size and status are accessed atomically, so that they can be read
+	// while the request is in flight. size is first to ensure that it is
+	// 64-bit aligned.
 	size           int64
-	hijacked       bool
+	status         int32
+	responseWriter http.ResponseWriter
+	superfluousStatus int
+	headerCount    int
+	hijacked       atomic.Bool
+	wroteHeader    bool
+	firstByte      time.Time
+	flushed        bool
+	sseEvents      int
+	sseFirstFlush  time.Time
+	// uncompressedSize is only valid if uncompressedSet is true.
+	uncompressedSize int64
+	uncompressedSet  bool
+	// superfluous, if it is not nil, is called for superfluous calls to
+	// WriteHeader.
+	superfluous func(statusCode int)
 }
 
 func (r *responseWriter) Write(p []byte) (int, error) {
+	r.writeBody()
 	n, err := r.responseWriter.Write(p)
-	r.size += int64(n)
+	atomic.AddInt64(&r.size, int64(n))
 	return n, err
 }
 
-func (r *responseWriter) WriteHeader(statusCode int) {
-	r.status = statusCode
-	r.responseWriter.WriteHeader(statusCode)
-}
-
-func (r *responseWriter) Header() http.Header {
-	return r.responseWriter.Header()
-}
-
-func (r *responseWriter) Status() int {
-	if r.status == 0 {
-		return 200
+// WriteString writes s using the wrapped writer's WriteString method, if it has
+// one, which avoids converting s to a byte slice.
+func (r *responseWriter) WriteString(s string) (int, error) {
+	sw, ok := r.responseWriter.(io.StringWriter)
+	if !ok {
+		return r.Write([]byte(s))
 	}
-	return r.status
-}
-
-func (r *responseWriter) Size() int64 {
-	return r.size
-}
-
-func (r *responseWriter) Hijacked() bool {
-	return r.hijacked
-}
-
-//
-type responseWriterCloseNotifier struct {
-	*responseWriter
-}
-
-func (r responseWriterCloseNotifier) CloseNotify() <-chan bool {
-	return r.responseWriter.responseWriter.(http.CloseNotifier).CloseNotify()
-}
-
-//
-type responseWriterFlusher struct {
-	*responseWriter
-}
-
-func (r responseWriterFlusher) Flush() {
-	r.responseWriter.responseWriter.(http.Flusher).Flush()
-}
-
-//
-type responseWriterCloseNotifierFlusher struct {
-	*responseWriter
-}
-
-func (r responseWriterCloseNotifierFlusher) CloseNotify() <-chan bool {
-	return r.responseWriter.responseWriter.(http.CloseNotifier).CloseNotify()
-}
-
-func (r responseWriterCloseNotifierFlusher) Flush() {
-	r.responseWriter.responseWriter.(http.Flusher).Flush()
-}
-
-//
-type responseWriterHijacker struct {
-	*responseWriter
-}
-
-func (r responseWriterHijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
-	r.responseWriter.hijacked = true
-	return r.responseWriter.responseWriter.(http.Hijacker).Hijack()
-}
-
-//
-type responseWriterCloseNotifierHijacker struct {
-	*responseWriter
-}
-
-func (r responseWriterCloseNotifierHijacker) CloseNotify() <-chan bool {
-	return r.responseWriter.responseWriter.(http.CloseNotifier).CloseNotify()
-}
-
-func (r responseWriterCloseNotifierHijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
-	r.responseWriter.hijacked = true
-	return r.responseWriter.responseWriter.(http.Hijacker).Hijack()
-}
-
-//
-type responseWriterFlusherHijacker struct {
-	*responseWriter
-}
-
-func (r responseWriterFlusherHijacker) Flush() {
-	r.responseWriter.responseWriter.(http.Flusher).Flush()
-}
-
-func (r responseWriterFlusherHijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
-	r.responseWriter.hijacked = true
-	return r.responseWriter.responseWriter.(http.Hijacker).Hijack()
-}
-
-//
-type responseWriterCloseNotifierFlusherHijacker struct {
-	*responseWriter
-}
-
-func (r responseWriterCloseNotifierFlusherHijacker) CloseNotify() <-chan bool {
-	return r.responseWriter.responseWriter.(http.CloseNotifier).CloseNotify()
-}
-
-func (r responseWriterCloseNotifierFlusherHijacker) Flush() {
-	r.responseWriter.responseWriter.(http.Flusher).Flush()
-}
-
-func (r responseWriterCloseNotifierFlusherHijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
-	r.responseWriter.hijacked = true
-	return r.responseWriter.responseWriter.(http.Hijacker).Hijack()
-}
-
-//
-type responseWriterPusher struct {
-	*responseWriter
-}
-
-func (r responseWriterPusher) Push(target string, opts *http.PushOptions) error {
-	// http.Server will start a new request handler for this which will be
-	// logged separately.
-	return r.responseWriter.responseWriter.(http.Pusher).Push(target, opts)
+	r.writeBody()
+	n, err := sw.WriteString(s)
+	atomic.AddInt64(&r.size, int64(n))
+	return n, err
 }
 
-//
-type responseWriterCloseNotifierPusher struct {
-	*responseWriter
+// ReadFrom copies from src to the wrapped writer, using its ReadFrom method if
+// it has one, so that optimizations such as sendfile are preserved when the
+// handler uses io.Copy.
+func (r *responseWriter) ReadFrom(src io.Reader) (int64, error) {
+	r.writeBody()
+	var n int64
+	var err error
+	if rf, ok := r.responseWriter.(io.ReaderFrom); ok {
+		n, err = rf.ReadFrom(src)
+	} else {
+		n, err = io.Copy(r.responseWriter, src)
+	}
+	atomic.AddInt64(&r.size, n)
+	return n, err
 }
 
-func (r responseWriterCloseNotifierPusher) CloseNotify() <-chan bool {
-	return r.responseWriter.responseWriter.(http.CloseNotifier).CloseNotify()
+func (r *responseWriter) WriteHeader(statusCode int) {
+	// Informational responses such as 103 Early Hints may be followed by the
+	// final response, so they are not recorded as the status.
+	// Later calls are ignored by the http package, so only the first final
+	// status is recorded as the status.
+	if statusCode >= 200 || statusCode == http.StatusSwitchingProtocols {
+		if r.headerCount++; r.headerCount == 1 {
+			atomic.StoreInt32(&r.status, int32(statusCode))
+		} else {
+			r.superfluousStatus = statusCode
+			if r.superfluous != nil {
+				r.superfluous(statusCode)
+			}
+		}
+	}
+	r.writeHeader()
+	r.responseWriter.WriteHeader(statusCode)
 }
 
-func (r responseWriterCloseNotifierPusher) Push(target string, opts *http.PushOptions) error {
-	// http.Server will start a new request handler for this which will be
-	// logged separately.
-	return r.responseWriter.responseWriter.(http.Pusher).Push(target, opts)
+// writeHeader records that the response header was written.
+func (r *responseWriter) writeHeader() {
+	if !r.wroteHeader {
+		r.wroteHeader = true
+		r.firstByte = time.Now()
+	}
 }
 
-//
-type responseWriterFlusherPusher struct {
-	*responseWriter
+// writeBody records that the response body is being written, which implicitly
+// writes the header if it was not written.
+func (r *responseWriter) writeBody() {
+	r.writeHeader()
+	if r.headerCount == 0 {
+		r.headerCount = 1
+		atomic.CompareAndSwapInt32(&r.status, 0, http.StatusOK)
+	}
 }
 
-func (r responseWriterFlusherPusher) Flush() {
-	r.responseWriter.responseWriter.(http.Flusher).Flush()
+func (r *responseWriter) Header() http.Header {
+	return r.responseWriter.Header()
 }
 
-func (r responseWriterFlusherPusher) Push(target string, opts *http.PushOptions) error {
-	// http.Server will start a new request handler for this which will be
-	// logged separately.
-	return r.responseWriter.responseWriter.(http.Pusher).Push(target, opts)
+func (r *responseWriter) Status() int {
+	if status := atomic.LoadInt32(&r.status); status != 0 {
+		return int(status)
+	}
+	if r.hijacked.Load() {
+		return 0
+	}
+	return 200
 }
 
-//
-type responseWriterCloseNotifierFlusherPusher struct {
-	*responseWriter
+func (r *responseWriter) SuperfluousStatus() int {
+	return r.superfluousStatus
 }
 
-func (r responseWriterCloseNotifierFlusherPusher) CloseNotify() <-chan bool {
-	return r.responseWriter.responseWriter.(http.CloseNotifier).CloseNotify()
+func (r *responseWriter) WriteHeaderCount() int {
+	return r.headerCount
 }
 
-func (r responseWriterCloseNotifierFlusherPusher) Flush() {
-	r.responseWriter.responseWriter.(http.Flusher).Flush()
+func (r *responseWriter) Size() int64 {
+	return atomic.LoadInt64(&r.size)
 }
 
-func (r responseWriterCloseNotifierFlusherHijacker) Push(target string, opts *http.PushOptions) error {
-	// http.Server will start a new request handler for this which will be
-	// logged separately.
-	return r.responseWriter.responseWriter.(http.Pusher).Push(target, opts)
+func (r *responseWriter) Hijacked() bool {
+	return r.hijacked.Load()
 }
 
-//
-type responseWriterHijackerPusher struct {
-	*responseWriter
+func (r *responseWriter) WroteHeader() bool {
+	return r.wroteHeader
 }
 
-func (r responseWriterHijackerPusher) Hijack() (net.Conn, *bufio.ReadWriter, error) {
-	r.responseWriter.hijacked = true
-	return r.responseWriter.responseWriter.(http.Hijacker).Hijack()
+func (r *responseWriter) FirstByte() time.Time {
+	return r.firstByte
 }
 
-func (r responseWriterHijackerPusher) Push(target string, opts *http.PushOptions) error {
-	// http.Server will start a new request handler for this which will be
-	// logged separately.
-	return r.responseWriter.responseWriter.(http.Pusher).Push(target, opts)
+func (r *responseWriter) Flushed() bool {
+	return r.flushed
 }
 
-//
-type responseWriterCloseNotifierHijackerPusher struct {
-	*responseWriter
+func (r *responseWriter) SSEEvents() int {
+	return r.sseEvents
 }
 
-func (r responseWriterCloseNotifierHijackerPusher) CloseNotify() <-chan bool {
-	return r.responseWriter.responseWriter.(http.CloseNotifier).CloseNotify()
+func (r *responseWriter) SSEFirstFlush() time.Time {
+	return r.sseFirstFlush
 }
 
-func (r responseWriterCloseNotifierHijackerPusher) Hijack() (net.Conn, *bufio.ReadWriter, error) {
-	r.responseWriter.hijacked = true
-	return r.responseWriter.responseWriter.(http.Hijacker).Hijack()
+func (r *responseWriter) Unwrap() http.ResponseWriter {
+	return r.responseWriter
 }
 
-func (r responseWriterCloseNotifierHijackerPusher) Push(target string, opts *http.PushOptions) error {
-	// http.Server will start a new request handler for this which will be
-	// logged separately.
-	return r.responseWriter.responseWriter.(http.Pusher).Push(target, opts)
+func (r *responseWriter) SetUncompressedSize(n int64) {
+	r.uncompressedSize, r.uncompressedSet = n, true
 }
 
-//
-type responseWriterFlusherHijackerPusher struct {
-	*responseWriter
+func (r *responseWriter) UncompressedSize() int64 {
+	switch {
+	case r.uncompressedSet:
+		return r.uncompressedSize
+	case r.responseWriter.Header().Get("Content-Encoding") == "":
+		return r.Size()
+	}
+	return -1
+}
+
+// flush flushes the underlying http.ResponseWriter. Flushes are only counted
+// for Server-Sent Events responses, to avoid overhead for other responses.
+func (r *responseWriter) flush() {
+	r.responseWriter.(http.Flusher).Flush()
+	r.flushed = true
+	ct := r.responseWriter.Header().Get("Content-Type")
+	if strings.HasPrefix(ct, "text/event-stream") {
+		if r.sseEvents == 0 {
+			r.sseFirstFlush = time.Now()
+		}
+		r.sseEvents++
+	}
 }
 
-func (r responseWriterFlusherHijackerPusher) Flush() {
-	r.responseWriter.responseWriter.(http.Flusher).Flush()
+// closeNotifierShim implements http.CloseNotifier for a responseWriter.
+type closeNotifierShim struct {
+	rw *responseWriter
 }
 
-func (r responseWriterFlusherHijackerPusher) Hijack() (net.Conn, *bufio.ReadWriter, error) {
-	r.responseWriter.hijacked = true
-	return r.responseWriter.responseWriter.(http.Hijacker).Hijack()
+func (s closeNotifierShim) CloseNotify() <-chan bool {
+	return s.rw.responseWriter.(http.CloseNotifier).CloseNotify()
 }
 
-func (r responseWriterFlusherHijackerPusher) Push(target string, opts *http.PushOptions) error {
-	// http.Server will start a new request handler for this which will be
-	// logged separately.
-	return r.responseWriter.responseWriter.(http.Pusher).Push(target, opts)
+// flusherShim implements http.Flusher for a responseWriter.
+type flusherShim struct {
+	rw *responseWriter
 }
 
-//
-type responseWriterCloseNotifierFlusherHijackerPusher struct {
-	*responseWriter
+func (s flusherShim) Flush() {
+	s.rw.flush()
 }
 
-func (r responseWriterCloseNotifierFlusherHijackerPusher) CloseNotify() <-chan bool {
-	return r.responseWriter.responseWriter.(http.CloseNotifier).CloseNotify()
+// hijackerShim implements http.Hijacker for a responseWriter.
+type hijackerShim struct {
+	rw *responseWriter
 }
 
-func (r responseWriterCloseNotifierFlusherHijackerPusher) Flush() {
-	r.responseWriter.responseWriter.(http.Flusher).Flush()
+func (s hijackerShim) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	s.rw.hijacked.Store(true)
+	return s.rw.responseWriter.(http.Hijacker).Hijack()
 }
 
-func (r responseWriterCloseNotifierFlusherHijackerPusher) Hijack() (net.Conn, *bufio.ReadWriter, error) {
-	r.responseWriter.hijacked = true
-	return r.responseWriter.responseWriter.(http.Hijacker).Hijack()
+// pusherShim implements http.Pusher for a responseWriter.
+type pusherShim struct {
+	rw *responseWriter
 }
 
-func (r responseWriterCloseNotifierFlusherHijackerPusher) Push(target string, opts *http.PushOptions) error {
+func (s pusherShim) Push(target string, opts *http.PushOptions) error {
 	// http.Server will start a new request handler for this which will be
 	// logged separately.
-	return r.responseWriter.responseWriter.(http.Pusher).Push(target, opts)
+	return s.rw.responseWriter.(http.Pusher).Push(target, opts)
 }
@@ -0,0 +1,464 @@
+package httplog
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Bits of the optional interfaces implemented by a writer, as used by
+// fakeWriters and interfacesOf.
+const (
+	hasCloseNotifier = 1 << iota
+	hasFlusher
+	hasHijacker
+	hasPusher
+)
+
+// baseWriter is a fake http.ResponseWriter with no optional interfaces. The
+// fake implementations of the optional interfaces record their calls in it.
+type baseWriter struct {
+	header   http.Header
+	status   int
+	body     []byte
+	closed   chan bool
+	flushes  int
+	hijacked bool
+	pushed   []string
+}
+
+func newBaseWriter() *baseWriter {
+	return &baseWriter{header: make(http.Header), closed: make(chan bool)}
+}
+
+func (b *baseWriter) Header() http.Header {
+	return b.header
+}
+
+func (b *baseWriter) Write(p []byte) (int, error) {
+	b.body = append(b.body, p...)
+	return len(p), nil
+}
+
+func (b *baseWriter) WriteHeader(statusCode int) {
+	b.status = statusCode
+}
+
+type fakeCloseNotifier struct{ b *baseWriter }
+
+func (f fakeCloseNotifier) CloseNotify() <-chan bool {
+	return f.b.closed
+}
+
+type fakeFlusher struct{ b *baseWriter }
+
+func (f fakeFlusher) Flush() {
+	f.b.flushes++
+}
+
+type fakeHijacker struct{ b *baseWriter }
+
+func (f fakeHijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	f.b.hijacked = true
+	return nil, nil, nil
+}
+
+type fakePusher struct{ b *baseWriter }
+
+func (f fakePusher) Push(target string, opts *http.PushOptions) error {
+	f.b.pushed = append(f.b.pushed, target)
+	return nil
+}
+
+// fakeWriters returns, for each subset of the optional interfaces, a writer
+// wrapping b that implements exactly that subset.
+var fakeWriters = [16]func(b *baseWriter) http.ResponseWriter{
+	func(b *baseWriter) http.ResponseWriter { return b },
+	func(b *baseWriter) http.ResponseWriter {
+		return struct {
+			*baseWriter
+			fakeCloseNotifier
+		}{b, fakeCloseNotifier{b}}
+	},
+	func(b *baseWriter) http.ResponseWriter {
+		return struct {
+			*baseWriter
+			fakeFlusher
+		}{b, fakeFlusher{b}}
+	},
+	func(b *baseWriter) http.ResponseWriter {
+		return struct {
+			*baseWriter
+			fakeCloseNotifier
+			fakeFlusher
+		}{b, fakeCloseNotifier{b}, fakeFlusher{b}}
+	},
+	func(b *baseWriter) http.ResponseWriter {
+		return struct {
+			*baseWriter
+			fakeHijacker
+		}{b, fakeHijacker{b}}
+	},
+	func(b *baseWriter) http.ResponseWriter {
+		return struct {
+			*baseWriter
+			fakeCloseNotifier
+			fakeHijacker
+		}{b, fakeCloseNotifier{b}, fakeHijacker{b}}
+	},
+	func(b *baseWriter) http.ResponseWriter {
+		return struct {
+			*baseWriter
+			fakeFlusher
+			fakeHijacker
+		}{b, fakeFlusher{b}, fakeHijacker{b}}
+	},
+	func(b *baseWriter) http.ResponseWriter {
+		return struct {
+			*baseWriter
+			fakeCloseNotifier
+			fakeFlusher
+			fakeHijacker
+		}{b, fakeCloseNotifier{b}, fakeFlusher{b}, fakeHijacker{b}}
+	},
+	func(b *baseWriter) http.ResponseWriter {
+		return struct {
+			*baseWriter
+			fakePusher
+		}{b, fakePusher{b}}
+	},
+	func(b *baseWriter) http.ResponseWriter {
+		return struct {
+			*baseWriter
+			fakeCloseNotifier
+			fakePusher
+		}{b, fakeCloseNotifier{b}, fakePusher{b}}
+	},
+	func(b *baseWriter) http.ResponseWriter {
+		return struct {
+			*baseWriter
+			fakeFlusher
+			fakePusher
+		}{b, fakeFlusher{b}, fakePusher{b}}
+	},
+	func(b *baseWriter) http.ResponseWriter {
+		return struct {
+			*baseWriter
+			fakeCloseNotifier
+			fakeFlusher
+			fakePusher
+		}{b, fakeCloseNotifier{b}, fakeFlusher{b}, fakePusher{b}}
+	},
+	func(b *baseWriter) http.ResponseWriter {
+		return struct {
+			*baseWriter
+			fakeHijacker
+			fakePusher
+		}{b, fakeHijacker{b}, fakePusher{b}}
+	},
+	func(b *baseWriter) http.ResponseWriter {
+		return struct {
+			*baseWriter
+			fakeCloseNotifier
+			fakeHijacker
+			fakePusher
+		}{b, fakeCloseNotifier{b}, fakeHijacker{b}, fakePusher{b}}
+	},
+	func(b *baseWriter) http.ResponseWriter {
+		return struct {
+			*baseWriter
+			fakeFlusher
+			fakeHijacker
+			fakePusher
+		}{b, fakeFlusher{b}, fakeHijacker{b}, fakePusher{b}}
+	},
+	func(b *baseWriter) http.ResponseWriter {
+		return struct {
+			*baseWriter
+			fakeCloseNotifier
+			fakeFlusher
+			fakeHijacker
+			fakePusher
+		}{b, fakeCloseNotifier{b}, fakeFlusher{b}, fakeHijacker{b}, fakePusher{b}}
+	},
+}
+
+// interfacesOf returns the set of optional interfaces that w implements.
+func interfacesOf(w http.ResponseWriter) int {
+	var set int
+	if _, ok := w.(http.CloseNotifier); ok {
+		set |= hasCloseNotifier
+	}
+	if _, ok := w.(http.Flusher); ok {
+		set |= hasFlusher
+	}
+	if _, ok := w.(http.Hijacker); ok {
+		set |= hasHijacker
+	}
+	if _, ok := w.(http.Pusher); ok {
+		set |= hasPusher
+	}
+	return set
+}
+
+func TestWrapResponseWriterInterfaces(t *testing.T) {
+	for set, fake := range fakeWriters {
+		base := fake(newBaseWriter())
+		if got := interfacesOf(base); got != set {
+			t.Fatalf("fake writer %04b implements %04b", set, got)
+		}
+		if got := interfacesOf(WrapResponseWriter(base)); got != set {
+			t.Errorf("wrapper of writer implementing %04b implements %04b", set, got)
+		}
+	}
+}
+
+func TestWrapResponseWriterPush(t *testing.T) {
+	// A writer implementing CloseNotifier, Flusher and Pusher must expose
+	// Push, and only the wrapper of a Hijacker may expose Hijack.
+	b := newBaseWriter()
+	w := WrapResponseWriter(fakeWriters[hasCloseNotifier|hasFlusher|hasPusher](b))
+	p, ok := w.(http.Pusher)
+	if !ok {
+		t.Fatal("wrapper does not implement http.Pusher")
+	}
+	if _, ok := w.(http.Hijacker); ok {
+		t.Error("wrapper implements http.Hijacker")
+	}
+	if err := p.Push("/style.css", nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(b.pushed) != 1 || b.pushed[0] != "/style.css" {
+		t.Errorf("pushed %q, want [/style.css]", b.pushed)
+	}
+}
+
+func TestWrapResponseWriterShims(t *testing.T) {
+	// Each shim must call through to the wrapped writer and update the
+	// statistics of the same ResponseWriter, whichever shims are combined.
+	for set, fake := range fakeWriters {
+		b := newBaseWriter()
+		base := fake(b)
+		w := WrapResponseWriter(base)
+		if w.Unwrap() != base {
+			t.Errorf("%04b: Unwrap did not return the wrapped writer", set)
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		if _, err := w.Write([]byte("data: x\n\n")); err != nil {
+			t.Fatal(err)
+		}
+		if w.Size() != 9 || string(b.body) != "data: x\n\n" {
+			t.Errorf("%04b: size %d, body %q", set, w.Size(), b.body)
+		}
+		if c, ok := w.(http.CloseNotifier); ok && c.CloseNotify() != b.closed {
+			t.Errorf("%04b: CloseNotify did not return the wrapped channel", set)
+		}
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+			if b.flushes != 1 || !w.Flushed() || w.SSEEvents() != 1 {
+				t.Errorf("%04b: flushes %d, Flushed %t, SSEEvents %d", set, b.flushes, w.Flushed(), w.SSEEvents())
+			}
+		}
+		if p, ok := w.(http.Pusher); ok {
+			if p.Push("/a", nil); len(b.pushed) != 1 {
+				t.Errorf("%04b: pushed %q", set, b.pushed)
+			}
+		}
+		if h, ok := w.(http.Hijacker); ok {
+			h.Hijack()
+			if !b.hijacked || !w.Hijacked() {
+				t.Errorf("%04b: hijacked %t, Hijacked %t", set, b.hijacked, w.Hijacked())
+			}
+		}
+	}
+}
+
+// deadlineWriter is a fake writer that supports the methods used by
+// http.ResponseController.
+type deadlineWriter struct {
+	*baseWriter
+	readDeadline, writeDeadline time.Time
+	fullDuplex                  bool
+}
+
+func (d *deadlineWriter) SetReadDeadline(t time.Time) error {
+	d.readDeadline = t
+	return nil
+}
+
+func (d *deadlineWriter) SetWriteDeadline(t time.Time) error {
+	d.writeDeadline = t
+	return nil
+}
+
+func (d *deadlineWriter) EnableFullDuplex() error {
+	d.fullDuplex = true
+	return nil
+}
+
+func TestWrapResponseWriterResponseController(t *testing.T) {
+	base := &deadlineWriter{baseWriter: newBaseWriter()}
+	rc := http.NewResponseController(WrapResponseWriter(base))
+	deadline := time.Now().Add(time.Minute)
+	if err := rc.SetReadDeadline(deadline); err != nil {
+		t.Fatal(err)
+	}
+	if err := rc.SetWriteDeadline(deadline); err != nil {
+		t.Fatal(err)
+	}
+	if err := rc.EnableFullDuplex(); err != nil {
+		t.Fatal(err)
+	}
+	if !base.readDeadline.Equal(deadline) || !base.writeDeadline.Equal(deadline) || !base.fullDuplex {
+		t.Errorf("read deadline %v, write deadline %v, full duplex %t; want %v, %v, true",
+			base.readDeadline, base.writeDeadline, base.fullDuplex, deadline, deadline)
+	}
+
+	// The methods are not supported if the wrapped writer lacks them.
+	rc = http.NewResponseController(WrapResponseWriter(newBaseWriter()))
+	if err := rc.SetWriteDeadline(deadline); !errors.Is(err, http.ErrNotSupported) {
+		t.Errorf("SetWriteDeadline returned %v, want %v", err, http.ErrNotSupported)
+	}
+}
+
+// readerFromWriter is a fake writer that implements io.ReaderFrom, as
+// http.ResponseWriter does to use sendfile.
+type readerFromWriter struct {
+	*baseWriter
+	readFroms int
+}
+
+func (r *readerFromWriter) ReadFrom(src io.Reader) (int64, error) {
+	r.readFroms++
+	return io.Copy(io.Discard, src)
+}
+
+func TestResponseWriterReadFrom(t *testing.T) {
+	base := &readerFromWriter{baseWriter: newBaseWriter()}
+	w := WrapResponseWriter(base)
+	// Hide the WriteTo method of the reader, as io.Copy would use it instead
+	// of ReadFrom.
+	n, err := io.Copy(w, struct{ io.Reader }{strings.NewReader("hello, world")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 12 || w.Size() != 12 || base.readFroms != 1 {
+		t.Errorf("copied %d, size %d, ReadFrom calls %d; want 12, 12, 1", n, w.Size(), base.readFroms)
+	}
+	if !w.WroteHeader() || w.WriteHeaderCount() != 1 {
+		t.Errorf("WroteHeader %t, WriteHeaderCount %d; want true, 1", w.WroteHeader(), w.WriteHeaderCount())
+	}
+}
+
+func BenchmarkResponseWriterReadFrom(b *testing.B) {
+	// The wrapper should preserve the sendfile path of the http package's
+	// writer, so serving a file through it should be no slower than serving
+	// it directly.
+	const size = 1 << 20
+	f, err := os.CreateTemp(b.TempDir(), "body")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.Write(make([]byte, size)); err != nil {
+		b.Fatal(err)
+	}
+	for _, bench := range []struct {
+		name string
+		wrap bool
+	}{{"direct", false}, {"wrapped", true}} {
+		b.Run(bench.name, func(b *testing.B) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if bench.wrap {
+					w = WrapResponseWriter(w)
+				}
+				f, err := os.Open(f.Name())
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				defer f.Close()
+				io.Copy(w, f)
+			}))
+			defer srv.Close()
+			b.ReportAllocs()
+			b.SetBytes(size)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				resp, err := srv.Client().Get(srv.URL)
+				if err != nil {
+					b.Fatal(err)
+				}
+				n, err := io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+				if err != nil || n != size {
+					b.Fatalf("read %d bytes, error %v; want %d bytes", n, err, size)
+				}
+			}
+		})
+	}
+}
+
+// stringWriter is a fake writer that implements io.StringWriter, as
+// http.ResponseWriter does.
+type stringWriter struct {
+	*baseWriter
+	writeStrings int
+}
+
+func (s *stringWriter) WriteString(str string) (int, error) {
+	s.writeStrings++
+	return len(str), nil
+}
+
+func TestResponseWriterWriteString(t *testing.T) {
+	base := &stringWriter{baseWriter: newBaseWriter()}
+	w := WrapResponseWriter(base)
+	if n, err := io.WriteString(w, "hello"); n != 5 || err != nil {
+		t.Fatal(n, err)
+	}
+	if w.Size() != 5 || base.writeStrings != 1 {
+		t.Errorf("size %d, WriteString calls %d; want 5, 1", w.Size(), base.writeStrings)
+	}
+
+	// Without WriteString on the wrapped writer, Write is used.
+	b := newBaseWriter()
+	w = WrapResponseWriter(b)
+	if n, err := io.WriteString(w, "hello"); n != 5 || err != nil {
+		t.Fatal(n, err)
+	}
+	if w.Size() != 5 || string(b.body) != "hello" {
+		t.Errorf("size %d, body %q; want 5, %q", w.Size(), b.body, "hello")
+	}
+}
+
+func BenchmarkResponseWriterWriteString(b *testing.B) {
+	// io.WriteString should not convert the string to a byte slice when the
+	// wrapped writer implements io.StringWriter. The Write case, in which the
+	// wrapped writer does not, is the baseline.
+	s := strings.Repeat("x", 1024)
+	b.Run("StringWriter", func(b *testing.B) {
+		w := WrapResponseWriter(&stringWriter{baseWriter: newBaseWriter()})
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			io.WriteString(w, s)
+		}
+	})
+	b.Run("Write", func(b *testing.B) {
+		base := newBaseWriter()
+		base.body = make([]byte, 0, len(s))
+		w := WrapResponseWriter(base)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			io.WriteString(w, s)
+			base.body = base.body[:0]
+		}
+	})
+}
@@ -0,0 +1,115 @@
+package httplog
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LoggingRoundTripper wraps an http.RoundTripper in order to log outbound
+// requests using the provided function. Records are populated from the
+// *http.Request and *http.Response, so the same formats can be used for
+// client and server logs, though values that only apply to servers, such as
+// the client address, are empty.
+//
+// A request is logged when the body of its response is closed, so that the
+// duration and Size include reading the body. A request whose response body
+// is never closed, which the http package also requires, is never logged,
+// and its record is never returned to the pool. If the RoundTripper returns
+// an error, the request is logged immediately with Status 0 and the error in
+// Record.Err. A 101 Switching Protocols response is also logged immediately,
+// and its body, which is an io.ReadWriteCloser for the upgraded connection,
+// is returned unchanged. As with LoggingHandler, the LogFn must copy the
+// record if it references it after returning, unless Pool is NoPool.
+type LoggingRoundTripper struct {
+	// RoundTripper performs the requests. If it is nil,
+	// http.DefaultTransport is used.
+	http.RoundTripper
+	LogFn
+	// Pool provides the records. If it is nil, DefaultRecordPool is used.
+	Pool RecordPool
+}
+
+// NewLoggingRoundTripper returns an http.RoundTripper that logs requests made
+// with rt using the given LogFn. If fn is nil, it uses DefaultLogFn.
+func NewLoggingRoundTripper(rt http.RoundTripper, fn LogFn) http.RoundTripper {
+	if fn == nil {
+		fn = DefaultLogFn
+	}
+	return &LoggingRoundTripper{RoundTripper: rt, LogFn: fn}
+}
+
+func (t *LoggingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	rt := t.RoundTripper
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	record := recordPool(t.Pool).Get()
+	record.Start()
+	record.Request.Update(req)
+	if record.Host == "" && req.URL != nil {
+		record.Host = req.URL.Host
+	}
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		record.Err = err
+		t.log(record)
+		return resp, err
+	}
+	record.FirstByte = time.Now()
+	record.Status = resp.StatusCode
+	record.Wrote = true
+	record.WriteHeaderCount = 1
+	record.Response.Header = resp.Header
+	if resp.StatusCode == http.StatusSwitchingProtocols {
+		// Wrapping the body would hide its Write method.
+		t.log(record)
+		return resp, nil
+	}
+	resp.Body = &loggingBody{ReadCloser: resp.Body, t: t, record: record}
+	return resp, nil
+}
+
+// log logs the completed record and returns it to the pool.
+func (t *LoggingRoundTripper) log(record *Record) {
+	record.End()
+	if t.LogFn != nil {
+		t.LogFn(record)
+	}
+	recordPool(t.Pool).Put(record)
+}
+
+// loggingBody counts the bytes read from a response body and logs the request
+// when the body is closed.
+type loggingBody struct {
+	io.ReadCloser
+	n      atomic.Int64
+	once   sync.Once
+	t      *LoggingRoundTripper
+	record *Record
+}
+
+func (b *loggingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n.Add(int64(n))
+	return n, err
+}
+
+func (b *loggingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() {
+		record := b.record
+		b.record = nil
+		record.Size = b.n.Load()
+		// The Transport removes the Content-Encoding header if it
+		// decompressed the body.
+		record.UncompressedSize = -1
+		if record.Response.Header.Get("Content-Encoding") == "" {
+			record.UncompressedSize = record.Size
+		}
+		b.t.log(record)
+	})
+	return err
+}
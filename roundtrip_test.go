@@ -0,0 +1,117 @@
+package httplog
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// roundTripFunc is an http.RoundTripper implemented by a function.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// upgradeBody is the body of a fake 101 Switching Protocols response, which
+// the http package makes writable.
+type upgradeBody struct {
+	io.Reader
+	written []byte
+}
+
+func (b *upgradeBody) Write(p []byte) (int, error) {
+	b.written = append(b.written, p...)
+	return len(p), nil
+}
+
+func (b *upgradeBody) Close() error {
+	return nil
+}
+
+func TestLoggingRoundTripper(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+		io.WriteString(w, "hello")
+	}))
+	defer srv.Close()
+	var lines []string
+	client := &http.Client{Transport: &LoggingRoundTripper{
+		LogFn: func(record *Record) {
+			lines = append(lines, record.Format("%m %U %s %B %{uncompressed}B"))
+		},
+	}}
+	resp, err := client.Get(srv.URL + "/x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(resp.Body); err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 0 {
+		t.Fatalf("logged %q before the body was closed", lines)
+	}
+	resp.Body.Close()
+	resp.Body.Close()
+	if want := "GET /x 418 5 5"; len(lines) != 1 || lines[0] != want {
+		t.Errorf("logged %q, want [%q]", lines, want)
+	}
+}
+
+func TestLoggingRoundTripperError(t *testing.T) {
+	errFailed := errors.New("failed")
+	var line string
+	rt := &LoggingRoundTripper{
+		RoundTripper: roundTripFunc(func(*http.Request) (*http.Response, error) {
+			return nil, errFailed
+		}),
+		LogFn: func(record *Record) {
+			line = record.Format("%s %{error}x")
+		},
+	}
+	req := httptest.NewRequest("GET", "http://example.com/", nil)
+	if _, err := rt.RoundTrip(req); err != errFailed {
+		t.Fatalf("got error %v, want %v", err, errFailed)
+	}
+	if want := "- failed"; line != want {
+		t.Errorf("logged %q, want %q", line, want)
+	}
+}
+
+func TestLoggingRoundTripperUpgrade(t *testing.T) {
+	// The body of a 101 response must remain writable, and the request is
+	// logged without waiting for it to be closed.
+	body := &upgradeBody{Reader: strings.NewReader("")}
+	var line string
+	rt := &LoggingRoundTripper{
+		RoundTripper: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: http.StatusSwitchingProtocols,
+				Header:     http.Header{"Upgrade": {"websocket"}},
+				Body:       body,
+				Request:    req,
+			}, nil
+		}),
+		LogFn: func(record *Record) {
+			line = record.Format("%s %{Upgrade}o")
+		},
+	}
+	resp, err := rt.RoundTrip(httptest.NewRequest("GET", "http://example.com/ws", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "101 websocket"; line != want {
+		t.Errorf("logged %q, want %q", line, want)
+	}
+	w, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok {
+		t.Fatalf("body %T is not an io.ReadWriteCloser", resp.Body)
+	}
+	io.WriteString(w, "frame")
+	if string(body.written) != "frame" {
+		t.Errorf("wrote %q to the connection, want %q", body.written, "frame")
+	}
+}
@@ -0,0 +1,71 @@
+package httplog
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// SampleLogFn returns a LogFn that calls fn for a random fraction of records
+// given by rate, which should be between 0 and 1. See Sampler for more options.
+func SampleLogFn(fn LogFn, rate float64) LogFn {
+	return (&Sampler{Rate: rate}).LogFn(fn)
+}
+
+// Sampler selects a fraction of records to be logged. A Sampler must not be
+// copied after first use.
+type Sampler struct {
+	// Rate is the fraction of records to log, between 0 and 1.
+	Rate float64
+	// ByRequestID makes the decision for records with a request ID
+	// deterministic, based on a hash of the ID, so that the same decision is
+	// made everywhere the ID is logged. Records without an ID are sampled
+	// randomly.
+	ByRequestID bool
+	// AlwaysLogErrors logs all records with a 5xx status, regardless of Rate.
+	AlwaysLogErrors bool
+	// Seed is used to seed the random number generator. If it is 0, the
+	// current time is used.
+	Seed int64
+
+	once sync.Once
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+// LogFn returns a LogFn that calls fn for records that are selected by s.
+func (s *Sampler) LogFn(fn LogFn) LogFn {
+	return func(record *Record) {
+		if s.Sample(record) {
+			fn(record)
+		}
+	}
+}
+
+// Sample reports whether record should be logged.
+func (s *Sampler) Sample(record *Record) bool {
+	if s.AlwaysLogErrors && record.Status >= 500 {
+		return true
+	}
+	if s.ByRequestID && record.RequestID != "" {
+		h := fnv.New64a()
+		h.Write([]byte(record.RequestID))
+		return float64(h.Sum64()>>11)/(1<<53) < s.Rate
+	}
+	return s.float64() < s.Rate
+}
+
+func (s *Sampler) float64() float64 {
+	s.once.Do(func() {
+		seed := s.Seed
+		if seed == 0 {
+			seed = time.Now().UnixNano()
+		}
+		s.rand = rand.New(rand.NewSource(seed))
+	})
+	s.mu.Lock()
+	f := s.rand.Float64()
+	s.mu.Unlock()
+	return f
+}
@@ -0,0 +1,274 @@
+package httplog
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultSyslogPriority is the priority used by a SyslogLogFn if none is
+// given: facility local0 and severity informational.
+const DefaultSyslogPriority = 16<<3 | 6
+
+// DefaultSyslogSDID is the structured-data ID used by a SyslogLogFn if none is
+// given. It uses the enterprise number reserved for documentation by RFC 5612,
+// so an ID with a registered enterprise number should be used if the data is
+// processed by other organizations.
+const DefaultSyslogSDID = "http@32473"
+
+// syslogSockets are the local syslog sockets tried when no address is given,
+// as with log/syslog.
+var syslogSockets = []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
+
+// syslogDialTimeout bounds how long connecting to a syslog server may take.
+const syslogDialTimeout = 5 * time.Second
+
+// syslogRetryInterval is how long a SyslogLogFn waits after failing to connect
+// before it tries again. Records logged in the meantime are dropped.
+const syslogRetryInterval = 5 * time.Second
+
+// Maximum lengths of RFC 5424 header fields.
+const (
+	syslogMaxHostname = 255
+	syslogMaxAppName  = 48
+)
+
+// SyslogLogFn sends records to a syslog server as RFC 5424 messages. Each
+// message has a structured-data element with the request method, response
+// status, response size in bytes and duration in seconds, followed by the
+// record formatted with Format. Its Log method can be used as a LogFn.
+//
+// Messages are written directly to the connection rather than through
+// log/syslog, which adds its own RFC 3164 header. If a write fails, the
+// connection is re-established and the message is sent again once; records
+// that still cannot be sent are dropped. Connecting does not block other
+// calls to Log, which drop their records while a connection is being
+// established, and for a few seconds after connecting fails, so that an
+// unreachable server does not hold up every request. Since writing may still
+// block, Log should usually be wrapped with an AsyncLogFn.
+//
+// The fields must not be modified after Log is first called.
+type SyslogLogFn struct {
+	// Network and Addr are the network and address of the syslog server, as
+	// for net.Dial, such as "udp" and "logs.example.com:514". TCP
+	// connections use octet-counting framing, as described by RFC 6587. If
+	// Addr is empty, the local syslog socket is used.
+	Network, Addr string
+	// Priority is the facility multiplied by 8 plus the severity, as with
+	// log/syslog's Priority, so int(syslog.LOG_LOCAL0|syslog.LOG_INFO) may
+	// be used. If it is 0, DefaultSyslogPriority is used.
+	Priority int
+	// SDID is the ID of the structured-data element. If it is empty,
+	// DefaultSyslogSDID is used.
+	SDID string
+	// AppName is the APP-NAME of messages. If it is empty, the base name of
+	// the executable is used. It is truncated to 48 characters.
+	AppName string
+	// Hostname is the HOSTNAME of messages. If it is empty, os.Hostname is
+	// used. It is truncated to 255 characters.
+	Hostname string
+	// Format is the format of the message text. If it is empty,
+	// DefaultFormat is used.
+	Format string
+
+	mu       sync.Mutex
+	conn     net.Conn
+	network  string
+	dialing  bool
+	retry    time.Time // when to connect again after a failure
+	hostname string
+	appName  string
+}
+
+// errSyslogUnavailable is returned by connection when it does not try to
+// connect.
+var errSyslogUnavailable = errors.New("syslog connection unavailable")
+
+// Log sends record to the syslog server, connecting first if necessary.
+func (s *SyslogLogFn) Log(record *Record) {
+	msg := s.message(record)
+	for attempt := 0; attempt < 2; attempt++ {
+		conn, network, err := s.connection()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		_, err = conn.Write(syslogFrame(network, msg))
+		if err != nil && s.conn == conn {
+			s.conn.Close()
+			s.conn = nil
+		}
+		s.mu.Unlock()
+		if err == nil {
+			return
+		}
+	}
+}
+
+// connection returns the connection to the syslog server and its network,
+// connecting if necessary. It returns errSyslogUnavailable without connecting
+// if another call is connecting or a recent attempt failed.
+func (s *SyslogLogFn) connection() (net.Conn, string, error) {
+	s.mu.Lock()
+	if s.conn != nil {
+		defer s.mu.Unlock()
+		return s.conn, s.network, nil
+	}
+	if s.dialing || time.Now().Before(s.retry) {
+		s.mu.Unlock()
+		return nil, "", errSyslogUnavailable
+	}
+	s.dialing = true
+	s.mu.Unlock()
+
+	conn, network, err := s.dial()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dialing = false
+	if err != nil {
+		s.retry = time.Now().Add(syslogRetryInterval)
+		return nil, "", err
+	}
+	s.conn, s.network = conn, network
+	return conn, network, nil
+}
+
+// Close closes the connection to the syslog server, if there is one. A later
+// call to Log reconnects.
+func (s *SyslogLogFn) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+// dial connects to the syslog server and returns the connection and its
+// network.
+func (s *SyslogLogFn) dial() (net.Conn, string, error) {
+	if s.Addr != "" {
+		conn, err := net.DialTimeout(s.Network, s.Addr, syslogDialTimeout)
+		return conn, s.Network, err
+	}
+	var err error
+	for _, path := range syslogSockets {
+		for _, network := range []string{"unixgram", "unix"} {
+			var conn net.Conn
+			if conn, err = net.DialTimeout(network, path, syslogDialTimeout); err == nil {
+				return conn, network, nil
+			}
+		}
+	}
+	return nil, "", err
+}
+
+// syslogFrame returns msg framed for a connection on network.
+func syslogFrame(network, msg string) []byte {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+		return []byte(strconv.Itoa(len(msg)) + " " + msg)
+	case "unix":
+		return []byte(msg + "\n")
+	}
+	return []byte(msg)
+}
+
+// message formats record as an RFC 5424 message.
+func (s *SyslogLogFn) message(record *Record) string {
+	s.mu.Lock()
+	if s.hostname == "" {
+		s.hostname = syslogName(s.Hostname, syslogMaxHostname, func() string {
+			h, _ := os.Hostname()
+			return h
+		})
+		s.appName = syslogName(s.AppName, syslogMaxAppName, func() string {
+			return filepath.Base(os.Args[0])
+		})
+	}
+	hostname, appName := s.hostname, s.appName
+	s.mu.Unlock()
+
+	priority, sdid, format := s.Priority, s.SDID, s.Format
+	if priority == 0 {
+		priority = DefaultSyslogPriority
+	}
+	if sdid == "" {
+		sdid = DefaultSyslogSDID
+	}
+	if format == "" {
+		format = DefaultFormat
+	}
+	timestamp := "-"
+	if !record.EndTime.IsZero() {
+		timestamp = record.EndTime.Format("2006-01-02T15:04:05.000000Z07:00")
+	}
+
+	var b strings.Builder
+	b.WriteByte('<')
+	b.WriteString(strconv.Itoa(priority))
+	b.WriteString(">1 ")
+	b.WriteString(timestamp)
+	b.WriteByte(' ')
+	b.WriteString(hostname)
+	b.WriteByte(' ')
+	b.WriteString(appName)
+	b.WriteByte(' ')
+	b.WriteString(pid)
+	b.WriteString(" access [")
+	b.WriteString(sdid)
+	writeSDParam(&b, "method", record.Method)
+	writeSDParam(&b, "status", strconv.Itoa(record.Status))
+	writeSDParam(&b, "bytes", strconv.FormatInt(record.Size, 10))
+	writeSDParam(&b, "duration", strconv.FormatFloat(record.Duration.Seconds(), 'f', -1, 64))
+	b.WriteString("] ")
+	b.WriteString(record.Format(format))
+	return b.String()
+}
+
+// syslogName returns name, or the result of fallback if name is empty,
+// reduced to the printable ASCII characters allowed in a header field and
+// truncated to maxLen characters, or "-" if none remain.
+func syslogName(name string, maxLen int, fallback func() string) string {
+	if name == "" {
+		name = fallback()
+	}
+	name = strings.Map(func(r rune) rune {
+		if r < '!' || r > '~' {
+			return -1
+		}
+		return r
+	}, name)
+	if name == "" {
+		return "-"
+	}
+	if len(name) > maxLen {
+		name = name[:maxLen]
+	}
+	return name
+}
+
+// writeSDParam writes a structured-data parameter to b, escaping '"', '\'
+// and ']' in value as required by RFC 5424.
+func writeSDParam(b *strings.Builder, name, value string) {
+	b.WriteByte(' ')
+	b.WriteString(name)
+	b.WriteString(`="`)
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; c {
+		case '"', '\\', ']':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+}
@@ -0,0 +1,167 @@
+package httplog
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// syslogRecord returns a record whose method must be escaped in an SD-PARAM.
+func syslogRecord() *Record {
+	r := completedRecord()
+	r.Method = `G"E\T]`
+	return r
+}
+
+// syslogHeader is the start of the message for syslogRecord, up to the
+// message text.
+var syslogHeader = `<134>1 2020-01-02T03:04:05.001500Z ` + strings.Repeat("h", 255) + ` ` +
+	strings.Repeat("a", 48) + ` ` + pid + ` access [http@32473 method="G\"E\\T\]" status="200" bytes="1234" duration="0.0015"] `
+
+// readFrame reads an octet-counted frame, as described by RFC 6587.
+func readFrame(r *bufio.Reader) (string, error) {
+	n, err := r.ReadString(' ')
+	if err != nil {
+		return "", err
+	}
+	size, err := strconv.Atoi(strings.TrimSuffix(n, " "))
+	if err != nil {
+		return "", err
+	}
+	msg := make([]byte, size)
+	_, err = io.ReadFull(r, msg)
+	return string(msg), err
+}
+
+func TestSyslogLogFnTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	frames := make(chan string)
+	go func() {
+		defer close(frames)
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		for {
+			msg, err := readFrame(r)
+			if err != nil {
+				return
+			}
+			frames <- msg
+		}
+	}()
+	s := &SyslogLogFn{
+		Network:  "tcp",
+		Addr:     ln.Addr().String(),
+		Hostname: strings.Repeat("h", 300),
+		AppName:  strings.Repeat("a", 60),
+		Format:   "%m %s",
+	}
+	defer s.Close()
+	r := syslogRecord()
+	s.Log(r)
+	r.Status = 404
+	s.Log(r)
+	for _, want := range []string{
+		syslogHeader + `G\"E\\T] 200`,
+		strings.Replace(syslogHeader, `status="200"`, `status="404"`, 1) + `G\"E\\T] 404`,
+	} {
+		select {
+		case got := <-frames:
+			if got != want {
+				t.Errorf("got message %q, want %q", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for message")
+		}
+	}
+}
+
+func TestSyslogLogFnUDP(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	s := &SyslogLogFn{
+		Network:  "udp",
+		Addr:     pc.LocalAddr().String(),
+		Hostname: strings.Repeat("h", 300),
+		AppName:  strings.Repeat("a", 60),
+		Format:   "%s",
+	}
+	defer s.Close()
+	s.Log(syslogRecord())
+	pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 2048)
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(buf[:n]), syslogHeader+"200"; got != want {
+		t.Errorf("got message %q, want %q", got, want)
+	}
+}
+
+func TestSyslogLogFnRetry(t *testing.T) {
+	// After failing to connect, Log drops records without connecting until
+	// the retry interval has passed.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	s := &SyslogLogFn{Network: "tcp", Addr: addr}
+	defer s.Close()
+	s.Log(syslogRecord())
+	if s.conn != nil || !s.retry.After(time.Now()) {
+		t.Fatalf("conn %v, retry %v after failing to connect", s.conn, s.retry)
+	}
+
+	ln, err = net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	s.Addr = ln.Addr().String()
+	s.Log(syslogRecord())
+	if s.conn != nil {
+		t.Fatal("connected before the retry interval passed")
+	}
+	s.retry = time.Time{}
+	s.Log(syslogRecord())
+	if s.conn == nil {
+		t.Fatal("did not connect after the retry interval passed")
+	}
+}
+
+func TestSyslogName(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		maxLen   int
+		fallback string
+		want     string
+	}{
+		{"web-1", 255, "", "web-1"},
+		{"", 255, "fallback", "fallback"},
+		{"my app\t√", 48, "", "myapp"},
+		{"", 48, " ", "-"},
+		{strings.Repeat("x", 50), 48, "", strings.Repeat("x", 48)},
+	} {
+		got := syslogName(test.name, test.maxLen, func() string { return test.fallback })
+		if got != test.want {
+			t.Errorf("syslogName(%q, %d) = %q, want %q", test.name, test.maxLen, got, test.want)
+		}
+	}
+}
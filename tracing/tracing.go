@@ -0,0 +1,40 @@
+// Package tracing correlates httplog records with OpenTelemetry traces.
+package tracing
+
+import (
+	"context"
+
+	"github.com/richshaffer/httplog"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// IDs returns the trace and span IDs of the span in ctx, or empty strings if
+// there is no valid span. It can be used as httplog.LoggingHandler.TraceIDs.
+func IDs(ctx context.Context) (traceID, spanID string) {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return "", ""
+	}
+	return sc.TraceID().String(), sc.SpanID().String()
+}
+
+// LogFn returns an httplog.LogFn that sets the response status code and body
+// size as attributes on the span in the record's request context, and then
+// calls fn, if it is not nil.
+func LogFn(fn httplog.LogFn) httplog.LogFn {
+	return func(record *httplog.Record) {
+		if record.Context != nil {
+			span := trace.SpanFromContext(record.Context)
+			if span.IsRecording() {
+				span.SetAttributes(
+					attribute.Int("http.response.status_code", record.Status),
+					attribute.Int64("http.response.body.size", record.Size),
+				)
+			}
+		}
+		if fn != nil {
+			fn(record)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/richshaffer/httplog"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// testSpanContext is a valid span context with fixed IDs.
+var testSpanContext = trace.NewSpanContext(trace.SpanContextConfig{
+	TraceID:    trace.TraceID{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36},
+	SpanID:     trace.SpanID{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7},
+	TraceFlags: trace.FlagsSampled,
+})
+
+// recordingSpan is a trace.Span that records the attributes set on it.
+type recordingSpan struct {
+	trace.Span
+	attrs []attribute.KeyValue
+}
+
+func (s *recordingSpan) IsRecording() bool {
+	return true
+}
+
+func (s *recordingSpan) SetAttributes(kv ...attribute.KeyValue) {
+	s.attrs = append(s.attrs, kv...)
+}
+
+func TestIDs(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		ctx  context.Context
+		want string
+	}{
+		{"span", trace.ContextWithSpanContext(context.Background(), testSpanContext),
+			"4bf92f3577b34da6a3ce929d0e0e4736 00f067aa0ba902b7"},
+		{"no span", context.Background(), "- -"},
+	} {
+		var line string
+		h := &httplog.LoggingHandler{
+			Handler:  http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}),
+			TraceIDs: IDs,
+			LogFn: func(record *httplog.Record) {
+				line = record.Format("%{trace-id}x %{span-id}x")
+			},
+		}
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil).WithContext(test.ctx))
+		if line != test.want {
+			t.Errorf("%s: logged %q, want %q", test.name, line, test.want)
+		}
+	}
+}
+
+func TestLogFn(t *testing.T) {
+	span := &recordingSpan{
+		Span: trace.SpanFromContext(trace.ContextWithSpanContext(context.Background(), testSpanContext)),
+	}
+	called := false
+	logFn := LogFn(func(*httplog.Record) { called = true })
+	record := httplog.NewRecord()
+	record.Context = trace.ContextWithSpan(context.Background(), span)
+	record.Status = http.StatusNotFound
+	record.Size = 9
+	logFn(record)
+	want := []attribute.KeyValue{
+		attribute.Int("http.response.status_code", 404),
+		attribute.Int64("http.response.body.size", 9),
+	}
+	if !reflect.DeepEqual(span.attrs, want) {
+		t.Errorf("set attributes %v, want %v", span.attrs, want)
+	}
+	if !called {
+		t.Error("wrapped LogFn was not called")
+	}
+
+	// Records without a context or a recording span are only passed on.
+	called = false
+	record.Context = nil
+	logFn(record)
+	record.Context = trace.ContextWithSpanContext(context.Background(), testSpanContext)
+	logFn(record)
+	LogFn(nil)(record)
+	if !called || len(span.attrs) != 2 {
+		t.Errorf("called %t, %d attributes; want true, 2", called, len(span.attrs))
+	}
+}
@@ -0,0 +1,136 @@
+package httplog
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ValidateFormat checks a format string for directives that Record.Format does
+// not understand, which it would otherwise pass through to the output. It
+// returns an error describing the first unknown directive, unterminated
+// "%{NAME}" directive or unknown name for directives that only accept certain
+// names, such as the unit of "%{UNIT}T". Custom directives registered with
+// RegisterDirective are accepted.
+func ValidateFormat(format string) error {
+	return DefaultFormatter.ValidateFormat(format)
+}
+
+// ValidateFormat is like the ValidateFormat function, but also accepts the
+// custom directives in f.Directives.
+func (f *Formatter) ValidateFormat(format string) error {
+	for i, l := 0, len(format); i < l; i++ {
+		if format[i] != '%' {
+			continue
+		}
+		start := i
+		if i++; i == l {
+			return fmt.Errorf("incomplete directive at %d", start)
+		}
+		if (format[i] == '<' || format[i] == '>') && i+1 < l && format[i+1] == 's' {
+			i++
+			continue
+		}
+		if format[i] != '{' {
+			if !strings.ContainsRune(simpleDirectives, rune(format[i])) &&
+				f.directive(format[i:i+1]) == nil {
+				return fmt.Errorf("unknown directive %q at %d", format[start:i+1], start)
+			}
+			continue
+		}
+		j := strings.IndexByte(format[i:], '}')
+		if j < 0 {
+			return fmt.Errorf("unterminated directive at %d", start)
+		}
+		j += i
+		key := format[i+1 : j]
+		if j++; j == l {
+			return fmt.Errorf("missing directive type after %q at %d", format[start:j], start)
+		}
+		i = j
+		if key == "" {
+			return fmt.Errorf("empty name in directive %q at %d", format[start:i+1], start)
+		}
+		if format[i] == 'X' {
+			if f.directive(key) == nil {
+				return fmt.Errorf("unknown directive %q at %d", format[start:i+1], start)
+			}
+			continue
+		}
+		if format[i] == '^' {
+			if i+2 >= l || format[i+1] != 't' || format[i+2] != 'i' && format[i+2] != 'o' {
+				return fmt.Errorf("unknown directive %q at %d", format[start:i+1], start)
+			}
+			i += 2
+			continue
+		}
+		names, ok := keyedDirectives[format[i]]
+		if !ok {
+			return fmt.Errorf("unknown directive %q at %d", format[start:i+1], start)
+		}
+		if names != nil && !names[key] && !validPrefixedName(format[i], key) {
+			return fmt.Errorf("unknown name %q in directive %q at %d", key, format[start:i+1], start)
+		}
+	}
+	return nil
+}
+
+// simpleDirectives lists the directives that have no name.
+const simpleDirectives = "%BDFHILOPRTUXabhklmpqrstuv"
+
+// keyedDirectives maps the types of "%{NAME}X" directives to the names they
+// accept, or nil if they accept any name.
+var keyedDirectives = map[byte]map[string]bool{
+	'B': {"uncompressed": true},
+	'C': nil,
+	'D': durationUnits,
+	'F': durationUnits,
+	'P': {"pid": true, "tid": true, "hextid": true},
+	'T': {"ns": true, "us": true, "ms": true, "s": true, "min": true,
+		"middleware_overhead": true, "sse_ttff": true},
+	'a': {"c": true},
+	'e': nil,
+	'i': nil,
+	'n': nil,
+	'o': nil,
+	'p': {"local": true, "remote": true},
+	't': nil,
+	'v': nil,
+	'x': {
+		"aborted":              true,
+		"alloc_delta":          true,
+		"cipher":               true,
+		"client_port":          true,
+		"clientcert_days_left": true,
+		"clientcert_expiry":    true,
+		"cn":                   true,
+		"error":                true,
+		"flushed":              true,
+		"grpc-status":          true,
+		"host":                 true,
+		"issuer":               true,
+		"proto":                true,
+		"request-id":           true,
+		"route":                true,
+		"scheme":               true,
+		"serial":               true,
+		"sni":                  true,
+		"span-id":              true,
+		"sse_events":           true,
+		"subject":              true,
+		"tag":                  true,
+		"trace-id":             true,
+		"version":              true,
+		"wh-count":             true,
+		"written":              true,
+	},
+}
+
+// durationUnits are the units accepted by "%{UNIT}T" and similar directives.
+var durationUnits = map[string]bool{"ns": true, "us": true, "ms": true, "s": true,
+	"min": true}
+
+// validPrefixedName reports whether key is a valid name for directive type
+// typ that has a variable suffix, such as "%{cc:NAME}x".
+func validPrefixedName(typ byte, key string) bool {
+	return typ == 'x' && strings.HasPrefix(key, "cc:") && len(key) > 3
+}
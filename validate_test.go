@@ -0,0 +1,88 @@
+package httplog
+
+import (
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestValidateFormat(t *testing.T) {
+	for _, format := range []string{
+		"",
+		"plain text",
+		SimpleLogFormat,
+		BasicLogFormat,
+		CommonLogFormat,
+		NCSALogFormat,
+		"%% %<s %>s %{ms}T %{us}D %{uncompressed}B %{session}C",
+		"%{begin:msec}t %{end:%Y}t %{Grpc-Status}^to %{X-Test}^ti",
+		"%{cc:max-age}x %{cipher}x %{c}a %{local}p %{pid}P",
+	} {
+		if err := ValidateFormat(format); err != nil {
+			t.Errorf("ValidateFormat(%q) returned %v", format, err)
+		}
+	}
+}
+
+func TestValidateFormatErrors(t *testing.T) {
+	for _, test := range []struct {
+		format, want string
+	}{
+		{"%", "incomplete directive at 0"},
+		{"abc %", "incomplete directive at 4"},
+		{"abc %Z", `unknown directive "%Z" at 4`},
+		{"%s %<x", `unknown directive "%<" at 3`},
+		{"%s %{foo", "unterminated directive at 3"},
+		{"%s %{foo}", `missing directive type after "%{foo}" at 3`},
+		{"%{}i", `empty name in directive "%{}i" at 0`},
+		{"%{foo}Z", `unknown directive "%{foo}Z" at 0`},
+		{"%h %{h}T", `unknown name "h" in directive "%{h}T" at 3`},
+		{"%{nope}x", `unknown name "nope" in directive "%{nope}x" at 0`},
+		{"%{cc:}x", `unknown name "cc:" in directive "%{cc:}x" at 0`},
+		{"%{X-Test}^tx", `unknown directive "%{X-Test}^" at 0`},
+		{"%{name}X", `unknown directive "%{name}X" at 0`},
+	} {
+		err := ValidateFormat(test.format)
+		if err == nil || err.Error() != test.want {
+			t.Errorf("ValidateFormat(%q) returned %v, want %q", test.format, err, test.want)
+		}
+	}
+}
+
+// acceptedDirectives returns an example of each directive accepted by
+// ValidateFormat, using "X-Test" for directives that accept any name.
+func acceptedDirectives() []string {
+	var directives []string
+	for _, c := range simpleDirectives {
+		directives = append(directives, "%"+string(c))
+	}
+	directives = append(directives, "%<s", "%>s", "%{X-Test}^ti", "%{X-Test}^to", "%{cc:max-age}x")
+	for typ, names := range keyedDirectives {
+		if names == nil {
+			directives = append(directives, "%{X-Test}"+string(typ))
+		}
+		for name := range names {
+			directives = append(directives, "%{"+name+"}"+string(typ))
+		}
+	}
+	sort.Strings(directives)
+	return directives
+}
+
+func TestValidateFormatRendered(t *testing.T) {
+	// Every directive accepted by ValidateFormat must be rendered by Format
+	// rather than passed through to the output.
+	r := completedRecord()
+	for _, directive := range acceptedDirectives() {
+		if err := ValidateFormat(directive); err != nil {
+			t.Errorf("ValidateFormat(%q) returned %v", directive, err)
+			continue
+		}
+		if directive == "%%" {
+			continue
+		}
+		if got := r.Format(directive); strings.Contains(got, directive) {
+			t.Errorf("Format(%q) passed the directive through: %q", directive, got)
+		}
+	}
+}